@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"./nebulas/util"
+)
+
+var errorInvalidAmount = errors.New("invalid amount")
+var errorAmountUnit = errors.New("unknown unit, use NAS, mNAS, µNAS or wei")
+var errorAmountPrecision = errors.New("amount is smaller than 1 wei")
+var errorAmountZero = errors.New("amount must be more than 0")
+
+// nasDecimals is the number of decimal places between wei and NAS.
+const nasDecimals = 18
+
+// unitDecimals maps the units users may write an amount in to their decimal places in wei.
+var unitDecimals = map[string]int{
+	"nas":  18,
+	"mnas": 15,
+	"µnas": 12,
+	"unas": 12,
+	"wei":  0,
+}
+
+var amountSyntax = regexp.MustCompile(`^([0-9]*)(?:\.([0-9]*))?(?:e([+-]?[0-9]{1,3}))?\s*([a-zµ]*)$`)
+
+// parseNAS reads an amount like "5", "0.5 NAS", "500 mNAS" or "1e-3 nas" exactly, in wei.
+// Amounts without a unit are NAS.
+func parseNAS(s string) (*util.Uint128, error) {
+	m := amountSyntax.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil || m[1]+m[2] == "" {
+		return nil, errorInvalidAmount
+	}
+	whole, frac, unit := m[1], m[2], m[4]
+
+	decimals := nasDecimals
+	if unit != "" {
+		d, ok := unitDecimals[unit]
+		if !ok {
+			return nil, errorAmountUnit
+		}
+		decimals = d
+	}
+
+	exp := 0
+	if m[3] != "" {
+		exp, _ = strconv.Atoi(m[3])
+	}
+
+	// Shift the decimal point of whole.frac right until it's gone.
+	digits := whole + frac
+	shift := decimals + exp - len(frac)
+	if shift >= 0 {
+		digits += strings.Repeat("0", shift)
+	} else {
+		cut := len(digits) + shift
+		if cut < 0 {
+			cut = 0
+		}
+		if strings.Trim(digits[cut:], "0") != "" {
+			return nil, errorAmountPrecision
+		}
+		digits = digits[:cut]
+	}
+
+	i, ok := new(big.Int).SetString("0"+digits, 10)
+	if !ok {
+		return nil, errorInvalidAmount
+	}
+	if i.Sign() == 0 {
+		return nil, errorAmountZero
+	}
+	return util.NewUint128FromBigInt(i)
+}
+
+// formatNAS writes a wei amount in NAS without losing precision, e.g. "1.5".
+func formatNAS(wei *util.Uint128) string {
+	s := wei.String()
+	if len(s) <= nasDecimals {
+		s = strings.Repeat("0", nasDecimals-len(s)+1) + s
+	}
+
+	whole, frac := s[:len(s)-nasDecimals], strings.TrimRight(s[len(s)-nasDecimals:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// amountNAS formats a wei amount stored as a string, like the ones in waiters and the ledger.
+func amountNAS(wei string) string {
+	v, err := util.NewUint128FromString(wei)
+	if err != nil {
+		return wei + " wei"
+	}
+	return formatNAS(v)
+}
@@ -1,26 +1,27 @@
 package main
 
 import (
-	"bytes"
-	"crypto/aes"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"strconv"
-	"strings"
+	"sync"
 
 	"./nebulas"
 )
 
-const mainnetURL = "https://mainnet.nebulas.io"
-const chainID = 1
-
 var errorNotInStorage = errors.New("account not in storage")
-var errorUnexpectedLength = errors.New("unexpected length")
 var errorDecodeJSON = errors.New("error decoding JSON response")
+var errorNoAccountList = errors.New("the deployed contract can't list its accounts, redeploy contract.js to migrate every key")
+
+// contractError is an error thrown by the contract, rather than one reaching the node.
+type contractError struct {
+	message string
+}
+
+func (e *contractError) Error() string {
+	return e.message
+}
 
 type response struct {
 	Result result `json:"result"`
@@ -47,179 +48,145 @@ var client = &http.Client{
 	},
 }
 
-func accountInfo(a *core.Address) (parsed map[string]interface{}, err error) {
-	url := fmt.Sprintf("%v/v1/user/accountstate", mainnetURL)
-	data := fmt.Sprintf(`{"address": %q}`, a)
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer([]byte(data)))
-	if err != nil {
-		return parsed, err
-	}
+var node = newRPCClient(active.url)
 
-	body := readBody(resp)
-	err = json.Unmarshal(body, &parsed)
-	if err != nil {
-		return parsed, err
-	}
-	return
+// contractStore keeps user keys in the accounts map of the Yo contract (contract.js).
+type contractStore struct {
+	migrating sync.Map
 }
 
-func postRawTx(data string) (*http.Response, error) {
-	url := fmt.Sprintf("%v/v1/user/rawtransaction", mainnetURL)
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer([]byte(data)))
+func (c *contractStore) Get(id string) (account, error) {
+	enc, err := c.getEncrypted(id)
 	if err != nil {
-		return nil, err
+		return account{}, err
 	}
 
-	return resp, nil
-}
-
-func getAddress(id int64) ([]byte, error) {
-	url := fmt.Sprintf("%v/v1/user/call", mainnetURL)
-	data := fmt.Sprintf(
-		`{"from":%q, "to":%q, "value":"0", "nonce":0, "gasPrice":"1000000", "gasLimit":"2000000", "contract":{"function":"getAccount", "args":"[\"%v\"]"}}`,
-		bot.addr,
-		contractAddress,
-		id,
-	)
-
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer([]byte(data)))
+	key, err := decrypt(enc)
 	if err != nil {
-		return nil, err
+		return account{}, err
 	}
 
-	body := readBody(resp)
-	r := response{}
-	err = json.Unmarshal(body, &r)
+	acc, err := newAccount(key)
 	if err != nil {
-		return nil, errorDecodeJSON
-	}
-
-	if r.Result.Result == "" {
-		return nil, errorNotInStorage
-	}
-
-	if r.Result.ExecuteErr != "" {
-		return nil, errors.New(r.Result.ExecuteErr)
+		return account{}, err
 	}
 
-	key, err := decrypt(r.Result.Result)
-	if err != nil {
-		return nil, err
+	if isLegacyEnvelope(enc) {
+		if _, busy := c.migrating.LoadOrStore(id, true); !busy {
+			go func() {
+				err := c.Put(id, acc)
+				if err != nil {
+					// Try again the next time the key is read.
+					c.migrating.Delete(id)
+					fmt.Printf("Migrating key of %v: %v\n", id, err)
+				}
+			}()
+		}
 	}
 
-	return key, nil
+	return acc, nil
 }
 
-func encrypt(acc account) (string, error) {
-	bytes, err := getPrivateKeyByteArray(acc)
+func (c *contractStore) Put(id string, acc account) error {
+	encrypted, err := encrypt(acc)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	bc, err := aes.NewCipher([]byte(os.Getenv("secret")))
-	if err != nil {
-		return "", err
-	}
+	args, _ := json.Marshal([]string{id, encrypted})
+	payload, _ := json.Marshal(callContract{"setAccount", string(args)})
 
-	var dst = make([]byte, 32)
-	for i := 0; i <= 32-16; i += 16 {
-		bc.Encrypt(dst[i:], bytes[i:])
+	ca, err := core.AddressParse(active.contract)
+	if err != nil {
+		return err
 	}
 
-	return hex.EncodeToString(dst), nil
-
+	_, err = submit(bot, ca, uint128(0), core.TxPayloadCallType, payload)
+	return err
 }
 
-func decrypt(d string) ([]byte, error) {
-	if len(d) == 66 {
-		d = strings.Trim(d, `"`)
-	}
-
-	if len(d) != 64 {
-		return nil, errorUnexpectedLength
-	}
-
-	data, err := hex.DecodeString(d)
-	if err != nil {
-		return nil, err
-	}
+// List pages through the IDs recorded by setAccount. Contracts deployed before
+// listAccounts was added return errorNoAccountList.
+func (c *contractStore) List() ([]string, error) {
+	var ids []string
+	for {
+		args, _ := json.Marshal([]int{len(ids), 100})
+		r, err := c.call("listAccounts", string(args))
+		if _, ok := err.(*contractError); ok && len(ids) == 0 {
+			return nil, errorNoAccountList
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	bc, err := aes.NewCipher([]byte(os.Getenv("secret")))
-	if err != nil {
-		return nil, err
-	}
+		var page []string
+		err = json.Unmarshal([]byte(r), &page)
+		if err != nil {
+			return nil, errorDecodeJSON
+		}
 
-	var dst = make([]byte, 32)
-	for i := 0; i <= 32-16; i += 16 {
-		bc.Decrypt(dst[i:], data[i:])
+		ids = append(ids, page...)
+		if len(page) < 100 {
+			return ids, nil
+		}
 	}
-
-	return dst, nil
 }
 
-func setAddress(acc account, id int64) error {
-	accountInfo, err := accountInfo(bot.addr)
+// Rotate stores the key of id again if it is still in the legacy format.
+func (c *contractStore) Rotate(id string) error {
+	enc, err := c.getEncrypted(id)
 	if err != nil {
 		return err
-	} else if accountInfo["error"] != nil {
-		return errors.New(accountInfo["error"].(string))
 	}
 
-	nonceRaw := accountInfo["result"].(map[string]interface{})["nonce"].(string)
-	nonce, _ := strconv.ParseUint(nonceRaw, 10, 64)
-
-	encrypted, err := encrypt(acc)
-	if err != nil {
-		return err
+	if !isLegacyEnvelope(enc) {
+		return nil
 	}
 
-	data := fmt.Sprintf(
-		`{"function":"setAccount", "args":"[\"%v\",\"%s\"]"}`,
-		id,
-		encrypted,
-	)
-
-	payload := []byte(data)
-	ca, err := core.AddressParse(contractAddress)
+	key, err := decrypt(enc)
 	if err != nil {
 		return err
 	}
 
-	tx, err := newTx(txParams{bot.addr, ca, uint128(0), nonce + 1, uint128(1000000), uint128(2000000), core.TxPayloadCallType, payload})
+	acc, err := newAccount(key)
 	if err != nil {
 		return err
 	}
 
-	err = signTransaction(bot, tx)
+	return c.Put(id, acc)
+}
+
+// getEncrypted returns the envelope stored in the contract for id.
+func (c *contractStore) getEncrypted(id string) (string, error) {
+	args, _ := json.Marshal([]string{id})
+	r, err := c.call("getAccount", string(args))
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	encoded, err := encodeRawTx(tx)
-	err = tx.VerifyIntegrity(chainID)
-	if err != nil {
-		return err
+	if r == "" || r == "null" {
+		return "", errorNotInStorage
 	}
 
-	resp, err := postRawTx(encoded)
+	return r, nil
+}
+
+func (c *contractStore) call(function, args string) (string, error) {
+	r, err := node.call(callRequest{
+		From:     bot.addr.String(),
+		To:       active.contract,
+		Value:    "0",
+		GasPrice: "1000000",
+		GasLimit: "2000000",
+		Contract: &callContract{function, args},
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if resp.StatusCode != 200 {
-		body := readBody(resp)
-		var parsed map[string]string
-		err := json.Unmarshal(body, &parsed)
-		if err != nil {
-			return err
-		}
-
-		if e := parsed["execute_err"]; e != "" {
-			return errors.New(e)
-		} else if e = parsed["error"]; e != "" {
-			return errors.New(e)
-		}
+	if r.ExecuteErr != "" {
+		return "", &contractError{r.ExecuteErr}
 	}
 
-	return nil
+	return r.Result, nil
 }
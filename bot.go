@@ -5,57 +5,86 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"net/url"
-	"os"
-	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"./nebulas"
-	"github.com/ChimeraCoder/anaconda"
+	"./nebulas/util"
 )
 
-const botID int64 = 997554387227684865
-
 type waiter struct {
-	StatusID            int64
-	SenderID            int64
-	SenderScreenName    string
-	RecipientID         int64
-	RecipientScreenName string
-	Amount              float64
+	StatusID      string
+	SenderID      string
+	SenderName    string
+	RecipientID   string
+	RecipientName string
+	// Amount is in wei.
+	Amount string
+	Memo   string `json:",omitempty"`
 }
 
-var api = anaconda.NewTwitterApiWithCredentials(
-	os.Getenv("accessToken"),
-	os.Getenv("accessSecret"),
-	os.Getenv("consumerKey"),
-	os.Getenv("consumerSecret"),
-)
+// UnmarshalJSON reads Amount in wei, or as the number of NAS that confirmations and
+// transactions stored before amounts were exact hold.
+func (w *waiter) UnmarshalJSON(data []byte) error {
+	type plain waiter
+	var v struct {
+		plain
+		Amount json.RawMessage
+	}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	*w = waiter(v.plain)
 
-var waitingForConfirmation = sync.Map{}
-var waitingForAddress = sync.Map{}
+	if len(v.Amount) == 0 {
+		return nil
+	}
+	if v.Amount[0] == '"' || string(v.Amount) == "null" {
+		return json.Unmarshal(v.Amount, &w.Amount)
+	}
+	amount, err := parseNAS(string(v.Amount))
+	if err != nil {
+		return err
+	}
+	w.Amount = amount.String()
+	return nil
+}
 
-// Wait for @bot mentions to instigate a transaction
-func stream() {
-	userStream := api.UserStream(nil)
+const helpText = "Available commands: help, address, balance, transfer, history, export, claim, schedule, limits"
 
-	for t := range userStream.C {
-		switch status := t.(type) {
-		case anaconda.Tweet:
-			if status.User.Id != botID {
-				amount, err := parseStatus(status)
-				if err == nil && amount != 0 && status.InReplyToStatusID != 0 {
-					go confirmUserTx(status, amount)
-				}
+// confirmTimeout is how long a tip waits for the sender to answer before it's dropped.
+const confirmTimeout = 5 * time.Minute
+
+// Wait for @bot mentions on p to instigate a transaction
+func serve(p Platform) {
+	events := make(chan interface{})
+	go func() {
+		err := p.Listen(events)
+		if err != nil {
+			fmt.Println(err)
+		}
+		close(events)
+	}()
+
+	for e := range events {
+		switch e := e.(type) {
+		case mention:
+			cmd, err := parseStatus(e)
+			if err == errorNotCommand {
+				serveMention(p, e)
+				continue
 			}
-		case anaconda.DirectMessage:
-			if confirmed := confirmUserTxResponse(status); !confirmed {
-				err := parseChatCmds(status)
+			if err != nil {
+				dm(p, e.Sender.ID, fmt.Sprintf("Sorry, I couldn't read your tip: %v.", err))
+				continue
+			}
+			go tip(p, e, cmd)
+		case directMessage:
+			if confirmed := confirmUserTxResponse(p, e); !confirmed {
+				err := parseChatCmds(p, e)
 				if err != nil {
-					api.PostDMToUserId(fmt.Sprintf("Sorry, something went wrong. Error: %v", err), status.SenderId)
+					dm(p, e.Sender.ID, fmt.Sprintf("Sorry, something went wrong. Error: %v", err))
 				}
 			}
 		default:
@@ -63,6 +92,27 @@ func stream() {
 	}
 }
 
+// serveMention starts the rain or giveaway a mention that isn't a tip asks for.
+func serveMention(p Platform, m mention) {
+	r, err := parseRain(m.Text)
+	switch {
+	case err == nil:
+		go rain(p, m, r)
+		return
+	case err != errorNotCommand:
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I couldn't read your rain: %v.", err))
+		return
+	}
+
+	g, err := parseGiveaway(m.Text)
+	switch {
+	case err == nil:
+		go askGiveaway(p, m, g)
+	case err != errorNotCommand:
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I couldn't read your giveaway: %v.", err))
+	}
+}
+
 func clean(s string) string {
 	return strings.TrimSpace(s)
 }
@@ -71,270 +121,493 @@ func cleanLower(s string) string {
 	return strings.TrimSpace(strings.ToLower(s))
 }
 
-func parseChatCmds(msg anaconda.DirectMessage) error {
-	m := cleanLower(msg.Text)
-
-	if len(m) >= 46 && strings.HasPrefix(m, "transfer ") {
-		var nonce uint64
-		senderAcc, err := getAcc(msg.SenderId, msg.SenderId, &nonce)
-		if err != nil {
-			return err
-		}
-
-		addr, err := core.AddressParse(clean(msg.Text)[9:44])
-		if err != nil {
-			return err
-		}
-
-		amount, err := strconv.ParseFloat(m[45:], 64)
-		if err != nil {
-			return err
-		}
-
-		amt := uint64(amount * 1000000000000000000)
-
-		tx, err := newTx(txParams{
-			senderAcc.addr,
-			addr,
-			uint128(amt),
-			nonce + 1,
-			uint128(1000000),
-			uint128(2000000),
-			core.TxPayloadBinaryType,
-			nil,
-		})
-		if err != nil {
-			return err
-		}
-
-		err = signTransaction(senderAcc, tx)
-		if err != nil {
-			return err
-		}
+func parseChatCmds(p Platform, msg directMessage) error {
+	cmd, err := parseChatCmd(msg.Text)
+	if err == errorUnknownCommand {
+		return nil
+	}
+	if err != nil {
+		dm(p, msg.Sender.ID, fmt.Sprintf("Sorry, I couldn't read that: %v.", err))
+		return nil
+	}
 
-		encoded, err := encodeRawTx(tx)
-		if err != nil {
-			return err
+	switch cmd.Name {
+	case "transfer":
+		if cmd.To == nil {
+			dm(p, msg.Sender.ID, `To transfer NAS to another address, type "transfer your_address_here amount"`)
+			return nil
 		}
 
-		err = tx.VerifyIntegrity(chainID)
-		if err != nil {
-			return err
+		if refuseSpend(p, msg.Sender.ID, cmd.Amount) {
+			return nil
 		}
 
-		resp, err := postRawTx(encoded)
-		if err != nil {
-			return err
+		w := waiter{SenderID: msg.Sender.ID, SenderName: msg.Sender.Name, Amount: cmd.Amount.String(), Memo: cmd.Memo}
+		c := confirmation{Platform: p.Name(), Waiter: w, To: cmd.To.String()}
+		if needsCode(c) {
+			askCode(p, c)
+			return nil
 		}
-
-		if resp.StatusCode != 200 {
-			body := readBody(resp)
-			var parsed map[string]string
-			err := json.Unmarshal(body, &parsed)
-			if err != nil {
-				return err
-			}
-			return errors.New(parsed["error"])
+		return transfer(p, w, c.To)
+	case "history":
+		go historyCmd(p, msg.Sender.ID, cmd.Page)
+	case "export history":
+		go exportHistoryCmd(p, msg.Sender.ID)
+	case "export":
+		// Key files aren't sent over chat: whoever can read the conversation would have the
+		// passphrase along with the file. The operator exports them with export-key.
+		dm(p, msg.Sender.ID, `To move your NAS to a wallet of your choice, type "transfer your_address_here amount". If you need your key itself, ask the operator of this bot for a key file. To export your history as CSV, type "export history".`)
+	case "claim":
+		go claimCmd(p, msg.Sender.ID)
+	case "schedule":
+		if cmd.Amount == nil {
+			dm(p, msg.Sender.ID, `To tip someone later or over and over, type "schedule 1 NAS to @name every week", "... in 3 days", "... at 2030-01-31 12:00" or "... cron 0 9 * * 1", with an @name or a NAS address. Times are in UTC. Type "schedule list" to see your scheduled tips.`)
+			return nil
 		}
-
-		api.PostDMToUserId("Transaction sent. View your pending transactions at https://explorer.nebulas.io/", msg.SenderId)
-		return nil
-	}
-
-	switch m {
+		go scheduleCmd(p, msg, cmd)
+	case "schedule list":
+		go listSchedulesCmd(p, msg.Sender.ID)
+	case "schedule cancel":
+		go setScheduleCmd(p, msg.Sender.ID, cmd.ScheduleID, scheduleCancelled)
+	case "schedule resume":
+		go setScheduleCmd(p, msg.Sender.ID, cmd.ScheduleID, scheduleActive)
+	case "limits":
+		go limitsCmd(p, msg.Sender.ID)
+	case "limits set":
+		go setLimitCmd(p, msg.Sender.ID, cmd.Limit, cmd.Amount)
+	case "limits reset":
+		go resetLimitsCmd(p, msg.Sender.ID)
 	case "help":
-		api.PostDMToUserId("Available commands: help, address, transfer", msg.SenderId)
+		dm(p, msg.Sender.ID, helpText)
 	case "address":
-		go func(msg anaconda.DirectMessage) {
-			var nonce uint64
-			a, err := getAcc(msg.SenderId, msg.SenderId, &nonce)
+		go func(msg directMessage) {
+			a, err := getAcc(msg.Sender.ID)
 			if err != nil {
 				fmt.Println(err)
-				api.PostDMToUserId("Sorry, something went wrong.", msg.SenderId)
+				dm(p, msg.Sender.ID, "Sorry, something went wrong.")
 			} else {
-				api.PostDMToUserId(fmt.Sprintf("Your NAS address is: %s", a.addr), msg.SenderId)
+				watch(p, msg.Sender.ID, a)
+				dm(p, msg.Sender.ID, fmt.Sprintf("Your NAS address is: %s", a.addr))
 			}
 		}(msg)
-	case "transfer":
-		api.PostDMToUserId(`To transfer NAS to another address, type "transfer your_address_here amount"`, msg.SenderId)
+	case "balance":
+		go func(msg directMessage) {
+			a, err := getAcc(msg.Sender.ID)
+			var s *accountState
+			if err == nil {
+				watch(p, msg.Sender.ID, a)
+				s, err = node.accountState(a.addr)
+			}
+			if err != nil {
+				fmt.Println(err)
+				dm(p, msg.Sender.ID, "Sorry, something went wrong.")
+				return
+			}
+			dm(p, msg.Sender.ID, fmt.Sprintf("Your balance is %v NAS.", formatNAS(s.Balance)))
+		}(msg)
 	}
 	return nil
 }
 
-func confirmUserTx(status anaconda.Tweet, amount float64) {
-	waitingForConfirmation.Store(status.User.Id, waiter{
-		status.Id,
-		status.User.Id,
-		status.User.ScreenName,
-		status.InReplyToUserID,
-		status.InReplyToScreenName,
-		amount,
-	})
-	msg := fmt.Sprintf("CONFIRMATION: Send %f NAS to @%v? (yes/NO)", amount, status.InReplyToScreenName)
-	_, err := api.PostDMToUserId(msg, status.User.Id)
+// tip confirms a tip with its sender, once it's clear who it goes to.
+func tip(p Platform, m mention, cmd tipCmd) {
+	if cmd.Recipient != "" {
+		r, err := p.LookupUser(cmd.Recipient)
+		if err != nil {
+			fmt.Println(err)
+			dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I don't know @%v.", cmd.Recipient))
+			return
+		}
+		m.Recipient = r
+	}
+	if m.Recipient.ID == "" {
+		dm(p, m.Sender.ID, `Who should I send it to? Reply to their post, or name them like "@NebBot send 5 NAS to @name".`)
+		return
+	}
+
+	confirmUserTx(p, m, cmd)
+}
+
+func confirmUserTx(p Platform, m mention, cmd tipCmd) {
+	if refuseSpend(p, m.Sender.ID, cmd.Amount) {
+		return
+	}
+
+	c := confirmation{Platform: p.Name(), Waiter: waiter{
+		m.StatusID,
+		m.Sender.ID,
+		m.Sender.Name,
+		m.Recipient.ID,
+		m.Recipient.Name,
+		cmd.Amount.String(),
+		cmd.Memo,
+	}, Deadline: time.Now().Add(confirmTimeout)}
+
+	askConfirmation(p, c, fmt.Sprintf("CONFIRMATION: Send %v NAS to @%v? (yes/NO)", formatNAS(cmd.Amount), m.Recipient.Name))
+}
+
+// askConfirmation stores c and asks its sender to answer msg with yes or no.
+func askConfirmation(p Platform, c confirmation, msg string) {
+	err := state.putConfirmation(c)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	go confirmTxTimeout(status.User.Id)
+	senderID := c.Waiter.SenderID
+	if cf, ok := p.(confirmer); ok {
+		err = cf.SendConfirmation(senderID, msg, confirmationID(c))
+	} else {
+		err = p.SendDM(senderID, msg)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	confirmTxTimeout(p, c)
 }
 
-func confirmUserTxResponse(dm anaconda.DirectMessage) bool {
-	if len(dm.Text) < 2 || len(dm.Text) > 4 {
+func confirmUserTxResponse(p Platform, msg directMessage) bool {
+	if text := clean(msg.Text); isCode(text) {
+		return confirmCodeResponse(p, msg.Sender.ID, text)
+	}
+	if len(msg.Text) < 2 || len(msg.Text) > 4 {
 		return false
 	}
-	if response := cleanLower(dm.Text); response == "yes" {
-		raw, ok := waitingForConfirmation.Load(dm.SenderId)
-		if ok == true {
-			w, _ := raw.(waiter)
-			waitingForConfirmation.Delete(w.SenderID)
-			hash, err := startTx(w)
-			if err == nil {
-				api.PostDMToUserId("Transaction sent. View your pending transactions at https://explorer.nebulas.io/", w.SenderID)
-				tweetTransactionSuccess(w, hash)
-			} else {
-				api.PostDMToUserId(fmt.Sprintf("Transaction failed.\nReason: %v", err), w.SenderID)
-			}
-			return true
-		} else if response == "no" {
-			cancelTx(dm.SenderId)
+	switch cleanLower(msg.Text) {
+	case "yes":
+		c, ok, err := state.takeConfirmation(msg.Sender.ID, msg.Answers)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if !ok {
+			return staleAnswer(p, msg)
+		}
+		if c.Code != "" || needsCode(c) {
+			askCode(p, c)
 			return true
 		}
+		startConfirmed(p, c)
+		return true
+	case "no":
+		c, ok, _ := state.confirmation(msg.Sender.ID)
+		if !ok || msg.Answers != "" && msg.Answers != confirmationID(c) {
+			return staleAnswer(p, msg)
+		}
+		cancelTx(p, msg.Sender.ID)
+		return true
 	}
 	return false
 }
 
-func getAcc(id int64, senderID int64, nonce *uint64) (acc account, err error) {
-	address, err2 := getAddress(id)
-	if err2 != nil {
-		if err2 == errorDecodeJSON {
-			err = err2
-			return
-		}
+// staleAnswer tells the sender of a button click that the confirmation it was sent with is
+// no longer pending, and reports whether msg was such a click. Typed answers are left to
+// the other commands.
+func staleAnswer(p Platform, msg directMessage) bool {
+	if msg.Answers == "" {
+		return false
+	}
+	dm(p, msg.Sender.ID, "That confirmation is no longer pending.")
+	return true
+}
 
-		if _, ok := waitingForAddress.Load(id); ok {
-			err = errors.New("generating address, please wait")
-			return
-		}
+// startConfirmed starts the tip, rain, giveaway, transfer or scheduled tip c, which its
+// sender just confirmed.
+func startConfirmed(p Platform, c confirmation) {
+	switch {
+	case len(c.Rain) > 0:
+		go startRain(p, c)
+		return
+	case c.Giveaway != nil:
+		go openGiveaway(p, c)
+		return
+	case c.Schedule != nil:
+		go addScheduleCmd(p, *c.Schedule)
+		return
+	case c.To != "":
+		go transferCmd(p, c.Waiter, c.To)
+		return
+	}
 
-		waitingForAddress.Store(senderID, true)
-		go time.AfterFunc(time.Second*90, func() { waitingForAddress.Delete(senderID) })
-		acc, err = newAccount(nil)
-		if err != nil {
-			return
-		}
+	w := c.Waiter
+	defer lockSpend(w.SenderID)()
+	hash, escrowID, err := startTx(p, w)
+	if err != nil {
+		dm(p, w.SenderID, fmt.Sprintf("Transaction failed.\nReason: %v", err))
+		return
+	}
+	dm(p, w.SenderID, active.explorerLink())
+	t := submittedTx{Hash: hash, SenderID: w.SenderID, Announce: &w}
+	e := ledgerEntry{
+		Kind:          ledgerTip,
+		SenderID:      w.SenderID,
+		SenderName:    w.SenderName,
+		RecipientID:   w.RecipientID,
+		RecipientName: w.RecipientName,
+		Amount:        w.Amount,
+		StatusID:      w.StatusID,
+		Memo:          w.Memo,
+	}
+	if escrowID != 0 {
+		t.Escrow = escrowID
+		e.Kind, e.RecipientID = ledgerEscrow, ""
+	}
+	trackTx(p, t, e)
+}
 
-		err = setAddress(acc, senderID)
-		if err == nil {
-		}
+// transfer sends w to the address to, outside of any platform.
+func transfer(p Platform, w waiter, to string) error {
+	defer lockSpend(w.SenderID)()
+	addr, err := core.AddressParse(to)
+	if err != nil {
+		return err
+	}
+	amount, err := util.NewUint128FromString(w.Amount)
+	if err != nil {
+		return err
+	}
+	err = checkSpend(w.SenderID, amount)
+	if err != nil {
+		return err
+	}
 
-	} else {
-		acc, err = newAccount(address)
-		if err != nil {
-			return
-		}
+	senderAcc, err := getAcc(w.SenderID)
+	if err != nil {
+		return err
+	}
 
-		var accInfo map[string]interface{}
-		accInfo, err = accountInfo(acc.addr)
-		if err != nil {
-			return
-		} else if accInfo["error"] != nil {
-			return acc, fmt.Errorf("Remote error: %s", accInfo["error"].(string))
-		}
+	hash, err := submit(senderAcc, addr, amount, core.TxPayloadBinaryType, nil)
+	if err != nil {
+		return err
+	}
+
+	dm(p, w.SenderID, active.explorerLink())
+	trackTx(p, submittedTx{Hash: hash, SenderID: w.SenderID}, ledgerEntry{
+		Kind:       ledgerTransfer,
+		SenderID:   w.SenderID,
+		SenderName: w.SenderName,
+		To:         to,
+		Amount:     w.Amount,
+		Memo:       w.Memo,
+	})
+	return nil
+}
 
-		nonceRaw := accInfo["result"].(map[string]interface{})["nonce"].(string)
-		*nonce, err = strconv.ParseUint(nonceRaw, 10, 64)
+// transferCmd sends the transfer w that was confirmed with a one-time code.
+func transferCmd(p Platform, w waiter, to string) {
+	err := transfer(p, w, to)
+	if err != nil {
+		dm(p, w.SenderID, fmt.Sprintf("Sorry, something went wrong. Error: %v", err))
 	}
-	return
 }
 
-func startTx(w waiter) (string, error) {
-	api.PostDMToUserId("Starting transaction...", w.SenderID)
+// getAcc returns the custodial account of id, creating and storing a new one
+// the first time the user shows up.
+func getAcc(id string) (acc account, err error) {
+	acc, err = keys.Get(id)
+	if err != errorNotInStorage {
+		return
+	}
 
-	var nonce uint64
-	senderAcc, err := getAcc(w.SenderID, w.SenderID, &nonce)
+	ok, err := state.reserveAddress(id, time.Second*90)
 	if err != nil {
-		return "", err
+		return
+	}
+	if !ok {
+		err = errors.New("generating address, please wait")
+		return
 	}
 
-	recipientAcc, err := getAcc(w.RecipientID, w.SenderID, &nonce)
+	acc, err = newAccount(nil)
 	if err != nil {
-		return "", err
+		return
 	}
 
-	amt := uint64(w.Amount * 1000000000000000000)
+	err = keys.Put(id, acc)
+	return
+}
 
-	tx, err := newTx(txParams{
-		senderAcc.addr,
-		recipientAcc.addr,
-		uint128(amt),
-		nonce + 1,
-		uint128(1000000),
-		uint128(2000000),
-		core.TxPayloadBinaryType,
-		nil,
-	})
+func startTx(p Platform, w waiter) (hash string, escrowID uint64, err error) {
+	dm(p, w.SenderID, "Starting transaction...")
+	return sendTip(p, w)
+}
+
+// sendTip sends the tip w. Tips to someone who has never used the bot go to the
+// bot's account instead, to be held in escrow until they claim it, and the ID of
+// that escrow is returned.
+func sendTip(p Platform, w waiter) (hash string, escrowID uint64, err error) {
+	senderAcc, err := getAcc(w.SenderID)
 	if err != nil {
-		return "", err
+		return
 	}
 
-	err = signTransaction(senderAcc, tx)
+	amount, err := util.NewUint128FromString(w.Amount)
+	if err != nil {
+		return
+	}
+	err = checkSpend(w.SenderID, amount)
 	if err != nil {
-		return "", err
+		return
 	}
 
-	encoded, err := encodeRawTx(tx)
-	err = tx.VerifyIntegrity(chainID)
+	known, err := hasAccount(w.RecipientID)
 	if err != nil {
-		return "", err
+		return
+	}
+	to := bot.addr
+	if !known && amount.Cmp(escrowMinimum) < 0 {
+		err = errorEscrowMinimum
+		return
+	}
+	if known {
+		var recipientAcc account
+		recipientAcc, err = getAcc(w.RecipientID)
+		if err != nil {
+			return
+		}
+		watch(p, w.RecipientID, recipientAcc)
+		to = recipientAcc.addr
 	}
 
-	resp, err := postRawTx(encoded)
+	if !known {
+		escrowID, err = escrowTip(p, w)
+		if err != nil {
+			return
+		}
+	}
+
+	hash, err = submit(senderAcc, to, amount, core.TxPayloadBinaryType, nil)
+	if escrowID == 0 {
+		return
+	}
 	if err != nil {
-		return "", err
+		state.setEscrowStatus(escrowID, escrowSending, escrowFailed)
+		return "", 0, err
 	}
+	err = state.fundEscrow(escrowID, hash)
+	if err != nil {
+		fmt.Println("escrow", escrowID, "wasn't funded by", hash, err)
+	}
+	return hash, escrowID, nil
+}
 
-	body := readBody(resp)
-	var parsed map[string]interface{}
-	err = json.Unmarshal(body, &parsed)
+// trackTx records a transaction that was just submitted, along with its ledger entry e,
+// and follows it in the background.
+func trackTx(p Platform, t submittedTx, e ledgerEntry) {
+	t.Platform = p.Name()
+	t.Status = txPending
+	t.Submitted = time.Now()
+
+	e.Platform = t.Platform
+	e.Hash = t.Hash
+	e.Status = t.Status
+	e.Created = t.Submitted
+	e.Updated = t.Submitted
+	err := state.addLedger(&e)
 	if err != nil {
-		return "", err
+		fmt.Println(err)
 	}
+	t.Ledger = e.Seq
 
-	if resp.StatusCode != 200 {
-		return "", errors.New(parsed["error"].(string))
+	err = state.putTx(t)
+	if err != nil {
+		fmt.Println(err)
 	}
 
-	return parsed["result"].(map[string]interface{})["txhash"].(string), nil
+	go followTx(p, t)
 }
 
-func cancelTx(senderID int64) {
-	api.PostDMToUserId("Transaction not sent.", senderID)
-	waitingForConfirmation.Delete(senderID)
-}
+// followTx waits for the transaction to be executed, DMs the outcome to the sender
+// and announces the tip once the chain has confirmed it.
+func followTx(p Platform, t submittedTx) {
+	r, err := tracker.wait(t.Hash)
+	switch err {
+	case nil:
+		t.Status = txSuccess
+	case errorTxFailed:
+		t.Status = txFailed
+	default:
+		t.Status = txUnknown
+	}
+	if err := state.putTx(t); err != nil {
+		fmt.Println(err)
+	}
+	if t.Ledger != 0 {
+		if err := state.setLedgerStatus(t.Ledger, t.Status); err != nil {
+			fmt.Println(err)
+		}
+	}
 
-func confirmTxTimeout(userID int64) {
-	time.Sleep(5 * time.Minute)
-	if _, ok := waitingForConfirmation.Load(userID); ok {
-		api.PostDMToUserId("TIMEOUT: Defaulted to NO. Transaction not sent.", userID)
-		waitingForConfirmation.Delete(userID)
+	if !t.Quiet {
+		dm(p, t.SenderID, txOutcome(t.Hash, r, err))
+	}
+	switch {
+	case err != nil || t.Announce == nil:
+	case t.Escrow != 0:
+		announceEscrow(p, *t.Announce, t.Hash)
+	default:
+		announceTx(p, *t.Announce, t.Hash)
 	}
 }
 
-func parseStatus(status anaconda.Tweet) (amount float64, err error) {
-	r, _ := regexp.Compile("@NebBot (send|gift|give|wire|grant|drop|donate) ")
-	if r.MatchString(status.Text) {
-		match := r.FindStringIndex(status.Text)
-		end := strings.Index(status.Text, " NAS")
+func cancelTx(p Platform, senderID string) {
+	dm(p, senderID, "Transaction not sent.")
+	err := state.deleteConfirmation(senderID)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
 
-		amount, err := strconv.ParseFloat(status.Text[match[len(match)-1]:end], 64)
+// confirmTxTimeout drops the confirmation once its deadline passes, unless it was answered
+// or replaced by a newer tip before that.
+func confirmTxTimeout(p Platform, c confirmation) {
+	time.AfterFunc(time.Until(c.Deadline), func() {
+		expired, err := state.expireConfirmation(c.Waiter.SenderID, c.Deadline)
 		if err != nil {
-			return 0, err
+			fmt.Println(err)
+		}
+		if expired {
+			dm(p, c.Waiter.SenderID, "TIMEOUT: Defaulted to NO. Transaction not sent.")
+		}
+	})
+}
+
+// resume picks up the confirmations and transactions a previous run left behind.
+func resume(ps []Platform) error {
+	byName := map[string]Platform{}
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+
+	cs, err := state.confirmations()
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if p, ok := byName[c.Platform]; ok {
+			confirmTxTimeout(p, c)
+		} else {
+			fmt.Printf("Platform %v is disabled, confirmation of %v stays pending.\n", c.Platform, c.Waiter.SenderID)
 		}
-		return amount, nil
 	}
-	return 0, errors.New("does not match")
+
+	ts, err := state.pendingTxs()
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if p, ok := byName[t.Platform]; ok {
+			go followTx(p, t)
+		} else {
+			fmt.Printf("Platform %v is disabled, transaction %v stays pending.\n", t.Platform, t.Hash)
+		}
+	}
+	return nil
+}
+
+func parseStatus(status mention) (tipCmd, error) {
+	return parseTip(status.Text)
 }
 
 func reaction() string {
@@ -352,10 +625,10 @@ func reaction() string {
 	return fmt.Sprintf("%s", w[rand.Intn(len(w))])
 }
 
-// Send a tweet in reply to the instigating tweet to confirm the transaction succeeded.
-func tweetTransactionSuccess(w waiter, hash string) {
-	v := url.Values{}
-
-	v.Add("in_reply_to_status_id", string(w.StatusID))
-	api.PostTweet(fmt.Sprintf("%v @%v sent %f NAS to @%v. TX: %v", reaction(), w.SenderScreenName, w.Amount, w.RecipientScreenName, hash), v)
+// Reply to the instigating mention to confirm the transaction succeeded.
+func announceTx(p Platform, w waiter, hash string) {
+	err := p.Reply(w.StatusID, fmt.Sprintf("%v @%v sent %v NAS to @%v. TX: %v", reaction(), w.SenderName, amountNAS(w.Amount), w.RecipientName, hash))
+	if err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runCommand runs a one-off maintenance command instead of the bot and returns the exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "migrate-keys":
+		return withConfig(migrateKeysCmd, args)
+	case "new-mnemonic":
+		return newMnemonicCmd()
+	case "derive-address":
+		return deriveAddressCmd(args)
+	case "export-key":
+		return withConfig(exportKeyCmd, args)
+	case "verify-giveaway":
+		return verifyGiveawayCmd(args)
+	default:
+		fmt.Printf("Unknown command %q\n", name)
+		return 2
+	}
+}
+
+// withConfig runs cmd if the bot is configured to send transactions. The other commands
+// run offline, so that anyone can check a giveaway without the bot's key.
+func withConfig(cmd func(args []string) int, args []string) int {
+	if err := configError(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return cmd(args)
+}
+
+// migrateKeysCmd rotates the stored keys of the given user IDs, or of the IDs read one
+// per line from stdin. With no IDs and an empty stdin every key in the store is rotated.
+// A contract that can't list its keys falls back to the users in the bot's state.
+func migrateKeysCmd(args []string) int {
+	if len(args) == 0 {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			args = append(args, s.Text())
+		}
+	}
+
+	fromState := false
+	if len(args) == 0 {
+		ids, err := keys.List()
+		if err == errorNoAccountList {
+			fmt.Printf("%v. Migrating the users in %v instead, pass any others as arguments.\n", err, stateFile())
+			ids, err = stateUserIDs()
+			fromState = true
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		args = ids
+	}
+
+	failed := 0
+	for _, arg := range args {
+		id := clean(arg)
+		err := keys.Rotate(id)
+		if err == errorNotInStorage && fromState {
+			// Recipients of escrowed tips have a ledger but no key.
+			continue
+		}
+		if err != nil {
+			fmt.Printf("%v: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%v: ok\n", id)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// stateUserIDs reads the users the bot has dealt with from its state, which the bot
+// mustn't have open.
+func stateUserIDs() ([]string, error) {
+	s, err := openState(stateFile())
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.userIDs()
+}
+
+// newMnemonicCmd prints a new mnemonic for the hd key store.
+func newMnemonicCmd() int {
+	m, err := newMnemonic()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(m)
+	return 0
+}
+
+// deriveAddressCmd prints the address the key store holds for each user ID, which
+// checks a restored mnemonic against the known addresses.
+func deriveAddressCmd(args []string) int {
+	if errorKeyStore != nil {
+		fmt.Println(errorKeyStore)
+		return 1
+	}
+	for _, id := range args {
+		acc, err := keys.Get(id)
+		if err != nil {
+			fmt.Printf("%v: %v\n", id, err)
+			return 1
+		}
+		fmt.Printf("%v: %v\n", id, acc.addr)
+	}
+	return 0
+}
+
+// exportKeyCmd prints the key of a user ID as a V3 key file, encrypted with the passphrase
+// read from the first line of stdin so it stays out of the shell history. Hand the file
+// and the passphrase to the user over different channels.
+func exportKeyCmd(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("Usage: export-key user_id < passphrase")
+		return 2
+	}
+
+	s := bufio.NewScanner(os.Stdin)
+	s.Scan()
+	passphrase := strings.TrimRight(s.Text(), "\r")
+	if len(passphrase) < 8 {
+		fmt.Println("passphrase: expected at least 8 characters on stdin")
+		return 2
+	}
+
+	acc, err := keys.Get(clean(args[0]))
+	var kf []byte
+	if err == nil {
+		kf, err = exportKeyFile(acc, passphrase)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(kf))
+	return 0
+}
+
+// verifyGiveawayCmd checks the proof the bot published for a giveaway and prints the
+// winners it draws. Its arguments are the hex VRF key, the ID of the giveaway post, the
+// hash of the closing block, the hex proof, the number of winners and the user IDs of
+// the entrants the bot published, which are read from stdin when they're left out.
+func verifyGiveawayCmd(args []string) int {
+	if len(args) < 5 {
+		fmt.Println("Usage: verify-giveaway key post_id block_hash proof winners [entrant_id...]")
+		return 2
+	}
+
+	pub, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+	if err != nil {
+		fmt.Println("key:", err)
+		return 2
+	}
+	proof, err := hex.DecodeString(strings.TrimPrefix(args[3], "0x"))
+	if err != nil {
+		fmt.Println("proof:", err)
+		return 2
+	}
+	n, err := strconv.Atoi(args[4])
+	if err != nil || n < 1 {
+		fmt.Printf("winners: expected a number, got %q\n", args[4])
+		return 2
+	}
+
+	entrants := args[5:]
+	if len(entrants) == 0 {
+		s := bufio.NewScanner(os.Stdin)
+		s.Split(bufio.ScanWords)
+		for s.Scan() {
+			if id := s.Text(); id != "Entrants:" {
+				entrants = append(entrants, id)
+			}
+		}
+	}
+
+	winners, err := verifyGiveaway(pub, args[1], args[2], proof, entrants, n)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	for _, id := range winners {
+		fmt.Println(id)
+	}
+	return 0
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"./nebulas"
+	"./nebulas/util"
+)
+
+var errorNotCommand = errors.New("not a command")
+var errorUnknownCommand = errors.New("unknown command")
+
+// tipVerbs are the words that make a mention of the bot a tip.
+var tipVerbs = map[string]bool{
+	"send":   true,
+	"gift":   true,
+	"give":   true,
+	"wire":   true,
+	"grant":  true,
+	"drop":   true,
+	"donate": true,
+}
+
+// rainVerbs are the words that make a mention of the bot a rain, split between many recipients.
+var rainVerbs = map[string]bool{
+	"rain":    true,
+	"airdrop": true,
+}
+
+// giveawayVerbs are the words that make a mention of the bot a giveaway among the replies to it.
+var giveawayVerbs = map[string]bool{
+	"giveaway": true,
+	"raffle":   true,
+}
+
+// commandError says which part of a command couldn't be read, it's meant to be shown to the user.
+type commandError struct {
+	Expected string
+	// Got is the offending word, empty if the command ended too early.
+	Got string
+}
+
+func (e *commandError) Error() string {
+	if e.Got == "" {
+		return e.Expected
+	}
+	return fmt.Sprintf("%v, got %q", e.Expected, e.Got)
+}
+
+// tipCmd is "@NebBot send amount [unit] [to] [@recipient] [memo]".
+type tipCmd struct {
+	Amount *util.Uint128
+	// Recipient is the handle the tip names, without the @. It's empty when the tip
+	// goes to the author of the post it replies to.
+	Recipient string
+	Memo      string
+}
+
+// rainCmd is "@NebBot rain amount [unit] [on] [@recipient...] [memo]". Without recipients,
+// the amount goes to everyone who replied to the post the mention replies to.
+type rainCmd struct {
+	Amount *util.Uint128
+	// Recipients are handles without the @.
+	Recipients []string
+	Memo       string
+}
+
+// giveawayCmd is "@NebBot giveaway amount [unit] [to n winners] [memo]". The winners are
+// drawn among the users who reply to the mention.
+type giveawayCmd struct {
+	Amount *util.Uint128
+	// Winners share the amount, there's 1 unless the command says otherwise.
+	Winners int
+	Memo    string
+}
+
+// chatCmd is a command sent to the bot in a DM. Only the fields of Name are set.
+type chatCmd struct {
+	// Name is one of help, address, balance, transfer, history, export, "export history", claim,
+	// schedule, "schedule list", "schedule cancel", "schedule resume", limits, "limits set" and "limits reset".
+	Name string
+	// To and Amount are set for transfer and schedule, unless they were sent alone to ask how they work.
+	// Amount is also the new limit of "limits set".
+	To     *core.Address
+	Amount *util.Uint128
+	Memo   string
+	// Recipient is the handle a schedule pays, without the @, when it doesn't pay To.
+	Recipient string
+	// When says when a schedule runs.
+	When scheduleSpec
+	// ScheduleID is the schedule to cancel or resume.
+	ScheduleID uint64
+	// Limit is the limit "limits set" lowers: tx, daily or code.
+	Limit string
+	// Page of history, starting at 1.
+	Page int
+}
+
+// token is a word of a command, and where it is in the text.
+type token struct {
+	text  string
+	start int
+}
+
+func tokenize(s string) []token {
+	var ts []token
+	start := -1
+	for i, r := range s {
+		switch {
+		case unicode.IsSpace(r) && start >= 0:
+			ts = append(ts, token{s[start:i], start})
+			start = -1
+		case !unicode.IsSpace(r) && start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		ts = append(ts, token{s[start:], start})
+	}
+	return ts
+}
+
+// word is a token without the punctuation a sentence puts after it, in lower case.
+func (t token) word() string {
+	return strings.ToLower(strings.TrimRight(t.text, ".,!?;:"))
+}
+
+func (t token) isHandle() bool {
+	return len(t.word()) > 1 && t.text[0] == '@'
+}
+
+// rest returns the text from token i on, as the user wrote it.
+func rest(s string, ts []token, i int) string {
+	if i >= len(ts) {
+		return ""
+	}
+	return strings.TrimSpace(s[ts[i].start:])
+}
+
+// parseTip reads the tip in a mention of the bot. It returns errorNotCommand for
+// mentions that don't ask for a tip, and a *commandError for tips it can't read.
+func parseTip(text string) (cmd tipCmd, err error) {
+	ts := tokenize(text)
+
+	i := verb(ts, tipVerbs)
+	if i < 0 {
+		return cmd, errorNotCommand
+	}
+
+	var hasUnit bool
+	cmd.Amount, i, hasUnit, err = parseAmount(ts, i+2)
+	if err != nil {
+		return cmd, err
+	}
+
+	if i < len(ts) && (ts[i].word() == "to" || ts[i].word() == "on") {
+		i++
+		if i >= len(ts) || !ts[i].isHandle() {
+			return cmd, &commandError{"expected an @handle", tokenText(ts, i)}
+		}
+	}
+	if i < len(ts) && ts[i].isHandle() {
+		cmd.Recipient = strings.TrimRight(ts[i].text, ".,!?;:")[1:]
+		i++
+	} else if !hasUnit && i < len(ts) {
+		return cmd, &commandError{"expected a unit (NAS, mNAS, µNAS or wei) or an @handle", ts[i].text}
+	}
+
+	cmd.Memo = rest(text, ts, i)
+	return cmd, nil
+}
+
+// parseRain reads the rain in a mention of the bot, like parseTip.
+func parseRain(text string) (cmd rainCmd, err error) {
+	ts := tokenize(text)
+
+	i := verb(ts, rainVerbs)
+	if i < 0 {
+		return cmd, errorNotCommand
+	}
+
+	var hasUnit bool
+	cmd.Amount, i, hasUnit, err = parseAmount(ts, i+2)
+	if err != nil {
+		return cmd, err
+	}
+
+	if i < len(ts) && (ts[i].word() == "on" || ts[i].word() == "to" || ts[i].word() == "among") {
+		i++
+		if i >= len(ts) || !ts[i].isHandle() {
+			return cmd, &commandError{"expected an @handle", tokenText(ts, i)}
+		}
+	}
+	for i < len(ts) && ts[i].isHandle() {
+		cmd.Recipients = append(cmd.Recipients, strings.TrimRight(ts[i].text, ".,!?;:")[1:])
+		i++
+	}
+	if len(cmd.Recipients) == 0 && !hasUnit && i < len(ts) {
+		return cmd, &commandError{"expected a unit (NAS, mNAS, µNAS or wei) or an @handle", ts[i].text}
+	}
+
+	cmd.Memo = rest(text, ts, i)
+	return cmd, nil
+}
+
+// parseGiveaway reads the giveaway in a mention of the bot, like parseTip.
+func parseGiveaway(text string) (cmd giveawayCmd, err error) {
+	ts := tokenize(text)
+
+	i := verb(ts, giveawayVerbs)
+	if i < 0 {
+		return cmd, errorNotCommand
+	}
+
+	var hasUnit bool
+	cmd.Amount, i, hasUnit, err = parseAmount(ts, i+2)
+	if err != nil {
+		return cmd, err
+	}
+
+	cmd.Winners = 1
+	j := i
+	if j < len(ts) && ts[j].word() == "to" {
+		j++
+	}
+	if j+1 < len(ts) && (ts[j+1].word() == "winners" || ts[j+1].word() == "winner") {
+		cmd.Winners, err = strconv.Atoi(ts[j].word())
+		if err != nil || cmd.Winners < 1 {
+			return cmd, &commandError{"expected a number of winners", ts[j].text}
+		}
+		i = j + 2
+	} else if j > i {
+		return cmd, &commandError{"expected a number of winners", tokenText(ts, j)}
+	} else if !hasUnit && i < len(ts) {
+		return cmd, &commandError{"expected a unit (NAS, mNAS, µNAS or wei) or a number of winners", ts[i].text}
+	}
+
+	cmd.Memo = rest(text, ts, i)
+	return cmd, nil
+}
+
+// verb returns the index of the @NebBot that is followed by one of verbs, or -1.
+func verb(ts []token, verbs map[string]bool) int {
+	for i := 0; i+1 < len(ts); i++ {
+		if ts[i].word() == "@nebbot" {
+			if verbs[ts[i+1].word()] {
+				return i
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// parseChatCmd reads a DM command. It returns errorUnknownCommand for messages that
+// aren't commands, and a *commandError for commands it can't read.
+func parseChatCmd(text string) (cmd chatCmd, err error) {
+	ts := tokenize(text)
+	if len(ts) == 0 {
+		return cmd, errorUnknownCommand
+	}
+
+	cmd.Name = ts[0].word()
+	switch cmd.Name {
+	case "help", "address", "balance", "claim":
+	case "transfer":
+		if len(ts) == 1 {
+			break
+		}
+		cmd.To, err = core.AddressParse(ts[1].text)
+		if err != nil {
+			return cmd, &commandError{"expected a NAS address", ts[1].text}
+		}
+		var i int
+		var hasUnit bool
+		cmd.Amount, i, hasUnit, err = parseAmount(ts, 2)
+		if err != nil {
+			return cmd, err
+		}
+		if !hasUnit && i < len(ts) {
+			return cmd, &commandError{"expected a unit (NAS, mNAS, µNAS or wei)", ts[i].text}
+		}
+		cmd.Memo = rest(text, ts, i)
+	case "history":
+		cmd.Page = 1
+		if len(ts) > 1 {
+			cmd.Page, err = strconv.Atoi(ts[1].word())
+			if err != nil || cmd.Page < 1 {
+				return cmd, &commandError{"expected a page number", ts[1].text}
+			}
+		}
+	case "export":
+		if len(ts) == 2 && ts[1].word() == "history" {
+			cmd.Name = "export history"
+		}
+	case "schedule", "schedules":
+		switch {
+		case cmd.Name == "schedules" || len(ts) == 2 && ts[1].word() == "list":
+			cmd.Name = "schedule list"
+		case len(ts) == 1:
+		case ts[1].word() == "cancel" || ts[1].word() == "resume":
+			cmd.Name = "schedule " + ts[1].word()
+			if len(ts) != 3 {
+				return cmd, &commandError{"expected a schedule number", tokenText(ts, 3)}
+			}
+			cmd.ScheduleID, err = strconv.ParseUint(strings.TrimPrefix(ts[2].word(), "#"), 10, 64)
+			if err != nil || cmd.ScheduleID == 0 {
+				return cmd, &commandError{"expected a schedule number", ts[2].text}
+			}
+		default:
+			err = parseSchedule(text, ts, &cmd, time.Now())
+			if err != nil {
+				return cmd, err
+			}
+		}
+	case "limits", "limit":
+		cmd.Name = "limits"
+		err = parseLimits(ts, &cmd)
+		if err != nil {
+			return cmd, err
+		}
+	default:
+		return cmd, errorUnknownCommand
+	}
+	return cmd, nil
+}
+
+// limitAliases maps the words for a limit to its name in limitNames.
+var limitAliases = map[string]string{
+	"tx":          "tx",
+	"transaction": "tx",
+	"daily":       "daily",
+	"day":         "daily",
+	"code":        "code",
+}
+
+// parseLimits reads "limits", "limits reset" or "limits name amount [unit]" into cmd.
+func parseLimits(ts []token, cmd *chatCmd) error {
+	if len(ts) == 1 {
+		return nil
+	}
+	if ts[1].word() == "reset" {
+		cmd.Name = "limits reset"
+		if len(ts) > 2 {
+			return &commandError{"expected nothing after reset", ts[2].text}
+		}
+		return nil
+	}
+
+	limit, ok := limitAliases[ts[1].word()]
+	if !ok {
+		return &commandError{"expected tx, daily, code or reset", ts[1].text}
+	}
+	cmd.Name, cmd.Limit = "limits set", limit
+
+	amount, i, hasUnit, err := parseAmount(ts, 2)
+	if err != nil {
+		return err
+	}
+	cmd.Amount = amount
+	if i < len(ts) {
+		if !hasUnit {
+			return &commandError{"expected a unit (NAS, mNAS, µNAS or wei)", ts[i].text}
+		}
+		return &commandError{"expected nothing after the amount", ts[i].text}
+	}
+	return nil
+}
+
+// parseSchedule reads "schedule amount [unit] to recipient when [memo]" into cmd. The
+// recipient is an @handle or a NAS address, and when is "every period", "in period",
+// "at date [time]" or "cron minute hour day month weekday", in UTC.
+func parseSchedule(text string, ts []token, cmd *chatCmd, now time.Time) error {
+	amount, i, hasUnit, err := parseAmount(ts, 1)
+	if err != nil {
+		return err
+	}
+	cmd.Amount = amount
+
+	if i >= len(ts) || ts[i].word() != "to" {
+		if !hasUnit && i < len(ts) {
+			return &commandError{`expected a unit (NAS, mNAS, µNAS or wei) or "to"`, ts[i].text}
+		}
+		return &commandError{`expected "to"`, tokenText(ts, i)}
+	}
+	i++
+
+	switch {
+	case i >= len(ts):
+		return &commandError{"expected an @handle or a NAS address", ""}
+	case ts[i].isHandle():
+		cmd.Recipient = strings.TrimRight(ts[i].text, ".,!?;:")[1:]
+	default:
+		cmd.To, err = core.AddressParse(ts[i].text)
+		if err != nil {
+			return &commandError{"expected an @handle or a NAS address", ts[i].text}
+		}
+	}
+	i++
+
+	cmd.When, i, err = parseWhen(ts, i, now)
+	if err != nil {
+		return err
+	}
+	cmd.Memo = rest(text, ts, i)
+	return nil
+}
+
+// scheduleUnits are the periods a schedule can be written in.
+var scheduleUnits = map[string]time.Duration{
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+}
+
+// parseWhen reads when a schedule runs from ts[i] on, and returns the index of the token after it.
+func parseWhen(ts []token, i int, now time.Time) (when scheduleSpec, next int, err error) {
+	if i >= len(ts) {
+		return when, i, &commandError{"expected every, in, at or cron", ""}
+	}
+
+	switch ts[i].word() {
+	case "every":
+		when.Every, next, err = parsePeriod(ts, i+1)
+		if err == nil && when.Every < scheduleMinInterval {
+			err = &commandError{fmt.Sprintf("tips can repeat at most every %v", formatPeriod(scheduleMinInterval)), ts[i+1].text}
+		}
+	case "in":
+		var d time.Duration
+		d, next, err = parsePeriod(ts, i+1)
+		if err == nil {
+			when.At = now.Add(d).UTC().Truncate(time.Minute)
+		}
+	case "at", "on":
+		when.At, next, err = parseDate(ts, i+1)
+		if err == nil && !when.At.After(now) {
+			err = &commandError{"expected a time in the future", ts[i+1].text}
+		}
+	case "cron":
+		if i+5 >= len(ts) {
+			return when, i, &commandError{errorInvalidCron.Error(), ""}
+		}
+		fields := make([]string, 5)
+		for k := range fields {
+			fields[k] = ts[i+1+k].text
+		}
+		when.Cron = strings.Join(fields, " ")
+		next = i + 6
+
+		var c *cronSpec
+		c, err = parseCron(when.Cron)
+		if err == nil {
+			switch {
+			case c.next(now).IsZero():
+				err = &commandError{"cron line never matches", when.Cron}
+			case c.repeatsWithin(now, scheduleMinInterval):
+				err = &commandError{fmt.Sprintf("tips can repeat at most every %v", formatPeriod(scheduleMinInterval)), when.Cron}
+			}
+		}
+	default:
+		return when, i, &commandError{"expected every, in, at or cron", ts[i].text}
+	}
+	return when, next, err
+}
+
+// parsePeriod reads a period like "week", "3 days" or "36h" at ts[i].
+func parsePeriod(ts []token, i int) (time.Duration, int, error) {
+	invalid := &commandError{`expected a period like "week", "3 days" or "12h"`, tokenText(ts, i)}
+	if i >= len(ts) {
+		return 0, i, invalid
+	}
+
+	w := ts[i].word()
+	if unit, ok := scheduleUnits[w]; ok {
+		return unit, i + 1, nil
+	}
+	if n, err := strconv.Atoi(w); err == nil && n > 0 && i+1 < len(ts) {
+		if unit, ok := scheduleUnits[ts[i+1].word()]; ok {
+			return time.Duration(n) * unit, i + 2, nil
+		}
+	}
+	if d, err := time.ParseDuration(w); err == nil && d > 0 {
+		return d, i + 1, nil
+	}
+	return 0, i, invalid
+}
+
+// parseDate reads "2006-01-02", optionally followed by "15:04", or an RFC 3339 time at ts[i].
+func parseDate(ts []token, i int) (time.Time, int, error) {
+	if i >= len(ts) {
+		return time.Time{}, i, &commandError{"expected a date like 2006-01-02 15:04", ""}
+	}
+
+	if t, err := time.Parse(time.RFC3339, ts[i].text); err == nil {
+		return t.UTC(), i + 1, nil
+	}
+	day, err := time.Parse("2006-01-02", ts[i].word())
+	if err != nil {
+		return time.Time{}, i, &commandError{"expected a date like 2006-01-02 15:04", ts[i].text}
+	}
+	if i+1 < len(ts) {
+		if t, err := time.Parse("2006-01-02 15:04", ts[i].word()+" "+ts[i+1].word()); err == nil {
+			return t, i + 2, nil
+		}
+	}
+	return day, i + 1, nil
+}
+
+// parseAmount reads the amount at ts[i], and its unit if that's the next word.
+// It returns the index of the token after the amount, and whether it named a unit.
+func parseAmount(ts []token, i int) (*util.Uint128, int, bool, error) {
+	if i >= len(ts) {
+		return nil, i, false, &commandError{"expected an amount", ""}
+	}
+
+	s := ts[i].word()
+	next := i + 1
+	if m := amountSyntax.FindStringSubmatch(s); m != nil && m[4] == "" && next < len(ts) {
+		if _, ok := unitDecimals[ts[next].word()]; ok {
+			s += " " + ts[next].word()
+			next++
+		}
+	}
+
+	v, err := parseNAS(s)
+	switch err {
+	case nil:
+		return v, next, amountSyntax.FindStringSubmatch(s)[4] != "", nil
+	case errorInvalidAmount:
+		return nil, i, false, &commandError{"expected an amount", ts[i].text}
+	case util.ErrUint128Overflow:
+		return nil, i, false, &commandError{"amount is too large", ts[i].text}
+	default:
+		return nil, i, false, &commandError{err.Error(), ts[i].text}
+	}
+}
+
+func tokenText(ts []token, i int) string {
+	if i >= len(ts) {
+		return ""
+	}
+	return ts[i].text
+}
@@ -3,9 +3,13 @@ const bot = "n1HY4ob2kBRacVHoJKGqmwsvhE6BN5pvEy2"
 class Yo {
   constructor() {
     LocalContractStorage.defineMapProperty(this, "accounts")
+    LocalContractStorage.defineMapProperty(this, "ids")
+    LocalContractStorage.defineProperty(this, "count")
   }
 
-  init() {}
+  init() {
+    this.count = 0
+  }
 
   getAccount(id) {
     if (Blockchain.transaction.from !== bot) throw new Error("unauthorized")
@@ -15,8 +19,23 @@ class Yo {
 
   setAccount(id, address) {
     if (Blockchain.transaction.from !== bot) throw new Error("unauthorized")
+    if (this.accounts.get(id) === null) {
+      const count = this.count || 0
+      this.ids.set(count, id)
+      this.count = count + 1
+    }
     this.accounts.set(id, address)
   }
+
+  listAccounts(offset, limit) {
+    if (Blockchain.transaction.from !== bot) throw new Error("unauthorized")
+    const ids = []
+    const count = this.count || 0
+    for (let i = offset; i < count && i < offset + limit; i++) {
+      ids.push(this.ids.get(i))
+    }
+    return ids
+  }
 }
 
 module.exports = Yo
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errorInvalidCron = errors.New("expected 5 cron fields: minute hour day month weekday")
+
+// cronSpec is a parsed "minute hour day month weekday" line. Every field is a bit set
+// of the values it matches. Times are in UTC.
+type cronSpec struct {
+	minute, hour, day, month, weekday uint64
+	// anyDay and anyWeekday are set for "*". Like cron, a spec that restricts both
+	// matches the days that match either of them.
+	anyDay, anyWeekday bool
+}
+
+// cronFields are the bounds of each field, in order.
+var cronFields = []struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron reads the 5 fields of a cron line. Fields are "*", numbers, ranges like "1-5"
+// and steps like "*/15" or "0-30/10", or comma separated lists of them. Weekday 7 is Sunday, like 0.
+func parseCron(s string) (*cronSpec, error) {
+	fields := strings.Fields(s)
+	if len(fields) != len(cronFields) {
+		return nil, errorInvalidCron
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		for _, part := range strings.Split(f, ",") {
+			set, err := parseCronPart(part, cronFields[i].min, cronFields[i].max)
+			if err != nil {
+				return nil, err
+			}
+			sets[i] |= set
+		}
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSpec{sets[0], sets[1], sets[2], sets[3], sets[4], fields[2] == "*", fields[4] == "*"}, nil
+}
+
+func parseCronPart(part string, min, max int) (uint64, error) {
+	invalid := &commandError{"expected a cron field", part}
+
+	step := 1
+	if i := strings.Index(part, "/"); i >= 0 {
+		var err error
+		step, err = strconv.Atoi(part[i+1:])
+		if err != nil || step < 1 {
+			return 0, invalid
+		}
+		part = part[:i]
+	}
+
+	lo, hi := min, max
+	if part != "*" {
+		bounds := strings.SplitN(part, "-", 2)
+		var err error
+		lo, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, invalid
+		}
+		hi = lo
+		if len(bounds) == 2 {
+			hi, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return 0, invalid
+			}
+		}
+	}
+	if lo < min || hi > max || lo > hi {
+		return 0, invalid
+	}
+
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << uint(v)
+	}
+	return set, nil
+}
+
+// next returns the first minute after t that c matches, or the zero time if it matches
+// nothing in the next 5 years, e.g. "0 0 31 2 *".
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// repeatsWithin reports whether two runs of c in the year after t are less than d apart.
+// A year covers every pattern of days and months, short of leap days.
+func (c *cronSpec) repeatsWithin(t time.Time, d time.Duration) bool {
+	end := t.AddDate(1, 0, 0)
+	prev := c.next(t)
+	for !prev.IsZero() && prev.Before(end) {
+		run := c.next(prev)
+		if !run.IsZero() && run.Sub(prev) < d {
+			return true
+		}
+		prev = run
+	}
+	return false
+}
+
+func (c *cronSpec) matchDay(t time.Time) bool {
+	day := c.day&(1<<uint(t.Day())) != 0
+	weekday := c.weekday&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
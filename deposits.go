@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"./nebulas"
+	"./nebulas/util"
+)
+
+// watchedUser is who to tell about deposits to an address.
+type watchedUser struct {
+	Platform string
+	UserID   string
+}
+
+// depositWatcher scans every new block for transfers to our users' addresses that the bot
+// didn't send itself, and DMs the user about them.
+type depositWatcher struct {
+	node     *rpcClient
+	interval time.Duration
+}
+
+var deposits = &depositWatcher{node, 15 * time.Second}
+
+// watch has deposits to acc reported to id on p. Users are watched once they have seen their address.
+func watch(p Platform, id string, acc account) {
+	err := state.watchAddress(acc.addr.String(), watchedUser{p.Name(), id})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (d *depositWatcher) run(ps []Platform) {
+	byName := map[string]Platform{}
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+
+	for {
+		err := d.scan(byName)
+		if err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(d.interval)
+	}
+}
+
+// scan checks the blocks added since the last scan. The very first scan only remembers
+// the tail, deposits from before the bot started watching aren't reported.
+func (d *depositWatcher) scan(byName map[string]Platform) error {
+	tail, err := d.node.height()
+	if err != nil {
+		return err
+	}
+
+	last, ok, err := state.scannedHeight()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return state.setScannedHeight(tail)
+	}
+
+	for h := last + 1; h <= tail; h++ {
+		b, err := d.node.blockByHeight(h)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range b.Transactions {
+			d.check(tx, byName)
+		}
+
+		err = state.setScannedHeight(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *depositWatcher) check(tx blockTx, byName map[string]Platform) {
+	if tx.Type != core.TxPayloadBinaryType || tx.Status != core.TxExecutionSuccess {
+		return
+	}
+
+	u, ok, err := state.watched(tx.To)
+	if err != nil || !ok {
+		return
+	}
+
+	// Tips and transfers the bot sent are reported by followTx.
+	if _, ours, _ := state.tx(tx.Hash); ours {
+		return
+	}
+
+	value, err := util.NewUint128FromString(tx.Value)
+	if err != nil || value.Cmp(util.Uint128Zero()) == 0 {
+		return
+	}
+
+	p, ok := byName[u.Platform]
+	if !ok {
+		return
+	}
+	dm(p, u.UserID, fmt.Sprintf("You received %v NAS from %v. TX: %v", formatNAS(value), tx.From, tx.Hash))
+}
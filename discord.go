@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errorGatewayReconnect = errors.New("discord gateway asked us to reconnect")
+var errorNotDiscordUser = errors.New("not a discord user ID")
+
+const (
+	discordPrefix = "discord:"
+
+	gatewayDispatch       = 0
+	gatewayHeartbeat      = 1
+	gatewayIdentify       = 2
+	gatewayReconnect      = 7
+	gatewayInvalidSession = 9
+	gatewayHello          = 10
+
+	// GUILD_MESSAGES, DIRECT_MESSAGES and MESSAGE_CONTENT
+	discordIntents = 1<<9 | 1<<12 | 1<<15
+)
+
+// discord is the Platform adapter for Discord. It reads messages from the gateway
+// websocket and answers through the REST API. User IDs are "discord:" + snowflake.
+type discord struct {
+	token   string
+	api     string
+	gateway string
+	// guild is searched by LookupUser, Discord has no global user search.
+	guild string
+
+	mu       sync.Mutex
+	botID    string
+	channels map[string]string
+}
+
+func newDiscord(getenv func(string) string) *discord {
+	api := getenv("discordAPI")
+	if api == "" {
+		api = "https://discord.com/api/v10"
+	}
+	return &discord{
+		token:    getenv("discordToken"),
+		api:      strings.TrimSuffix(api, "/"),
+		gateway:  getenv("discordGateway"),
+		guild:    getenv("discordGuild"),
+		channels: map[string]string{},
+	}
+}
+
+type gatewayPayload struct {
+	Op   int             `json:"op"`
+	D    json.RawMessage `json:"d,omitempty"`
+	Seq  *int64          `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
+}
+
+type discordMessage struct {
+	ID                string          `json:"id"`
+	ChannelID         string          `json:"channel_id"`
+	GuildID           string          `json:"guild_id"`
+	Author            discordUser     `json:"author"`
+	Content           string          `json:"content"`
+	Mentions          []discordUser   `json:"mentions"`
+	ReferencedMessage *discordMessage `json:"referenced_message"`
+}
+
+func (d *discord) Name() string {
+	return "discord"
+}
+
+// Listen keeps a gateway session open, reconnecting after errors, until Discord
+// closes it for good, e.g. because the token was rejected.
+func (d *discord) Listen(events chan<- interface{}) error {
+	for {
+		err := d.session(events)
+		if fatalGatewayClose(err) {
+			return err
+		}
+		if err != errorGatewayReconnect {
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// fatalGatewayClose reports whether Discord closed the gateway for a reason reconnecting can't fix.
+func fatalGatewayClose(err error) bool {
+	e, ok := err.(*websocket.CloseError)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case 4004, 4010, 4011, 4012, 4013, 4014:
+		return true
+	}
+	return false
+}
+
+// session identifies on a fresh gateway connection and forwards messages until it drops.
+// Sessions are never resumed, messages sent while reconnecting are lost.
+func (d *discord) session(events chan<- interface{}) error {
+	gateway := d.gateway
+	if gateway == "" {
+		var r struct {
+			URL string `json:"url"`
+		}
+		err := d.do("GET", "/gateway/bot", nil, &r)
+		if err != nil {
+			return err
+		}
+		gateway = r.URL
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(gateway+"?v=10&encoding=json", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	p := gatewayPayload{}
+	err = conn.ReadJSON(&p)
+	if err != nil {
+		return err
+	}
+	if p.Op != gatewayHello || json.Unmarshal(p.D, &hello) != nil {
+		return errorDecodeJSON
+	}
+
+	// Heartbeats are written from another goroutine and websocket.Conn allows one writer.
+	var writeMu sync.Mutex
+	var seq *int64
+	send := func(op int, d interface{}) error {
+		raw, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(gatewayPayload{Op: op, D: raw})
+	}
+
+	err = send(gatewayIdentify, map[string]interface{}{
+		"token":      d.token,
+		"intents":    discordIntents,
+		"properties": map[string]string{"os": "linux", "browser": "neby", "device": "neby"},
+	})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(time.Duration(hello.HeartbeatInterval) * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				writeMu.Lock()
+				s := seq
+				writeMu.Unlock()
+				if send(gatewayHeartbeat, s) != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		p := gatewayPayload{}
+		err = conn.ReadJSON(&p)
+		if err != nil {
+			return err
+		}
+
+		switch p.Op {
+		case gatewayDispatch:
+			writeMu.Lock()
+			seq = p.Seq
+			writeMu.Unlock()
+			d.dispatch(p, events)
+		case gatewayHeartbeat:
+			writeMu.Lock()
+			s := seq
+			writeMu.Unlock()
+			err = send(gatewayHeartbeat, s)
+			if err != nil {
+				return err
+			}
+		case gatewayReconnect, gatewayInvalidSession:
+			return errorGatewayReconnect
+		}
+	}
+}
+
+func (d *discord) dispatch(p gatewayPayload, events chan<- interface{}) {
+	switch p.Type {
+	case "READY":
+		var ready struct {
+			User discordUser `json:"user"`
+		}
+		if json.Unmarshal(p.D, &ready) == nil {
+			d.mu.Lock()
+			d.botID = ready.User.ID
+			d.mu.Unlock()
+		}
+	case "MESSAGE_CREATE":
+		var msg discordMessage
+		if json.Unmarshal(p.D, &msg) != nil || msg.Author.Bot {
+			return
+		}
+
+		sender := user{discordPrefix + msg.Author.ID, msg.Author.Username}
+		if msg.GuildID == "" {
+			events <- directMessage{sender, msg.Content, ""}
+			return
+		}
+		if !d.mentionsBot(msg) {
+			return
+		}
+
+		m := mention{
+			StatusID: msg.ChannelID + "/" + msg.ID,
+			Sender:   sender,
+			Text:     d.plainText(msg),
+		}
+		if r := msg.ReferencedMessage; r != nil {
+			m.Recipient = user{discordPrefix + r.Author.ID, r.Author.Username}
+		}
+		events <- m
+	}
+}
+
+func (d *discord) mentionsBot(msg discordMessage) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, u := range msg.Mentions {
+		if u.ID == d.botID {
+			return true
+		}
+	}
+	return false
+}
+
+// plainText rewrites <@id> mentions to @name, with the bot as @NebBot like on Twitter.
+func (d *discord) plainText(msg discordMessage) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	text := msg.Content
+	for _, u := range msg.Mentions {
+		name := "@" + u.Username
+		if u.ID == d.botID {
+			name = "@NebBot"
+		}
+		text = strings.Replace(text, "<@"+u.ID+">", name, -1)
+		text = strings.Replace(text, "<@!"+u.ID+">", name, -1)
+	}
+	return text
+}
+
+func (d *discord) SendDM(userID string, text string) error {
+	if !strings.HasPrefix(userID, discordPrefix) {
+		return errorNotDiscordUser
+	}
+	id := userID[len(discordPrefix):]
+
+	d.mu.Lock()
+	channel, ok := d.channels[id]
+	d.mu.Unlock()
+
+	if !ok {
+		var c struct {
+			ID string `json:"id"`
+		}
+		err := d.do("POST", "/users/@me/channels", map[string]string{"recipient_id": id}, &c)
+		if err != nil {
+			return err
+		}
+		channel = c.ID
+
+		d.mu.Lock()
+		d.channels[id] = channel
+		d.mu.Unlock()
+	}
+
+	return d.do("POST", "/channels/"+channel+"/messages", map[string]string{"content": text}, nil)
+}
+
+func (d *discord) Reply(statusID string, text string) error {
+	ids := strings.SplitN(statusID, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("invalid discord message ID %q", statusID)
+	}
+
+	return d.do("POST", "/channels/"+ids[0]+"/messages", map[string]interface{}{
+		"content":           text,
+		"message_reference": map[string]string{"message_id": ids[1]},
+	}, nil)
+}
+
+func (d *discord) LookupUser(name string) (user, error) {
+	if d.guild == "" {
+		return user{}, errorUnknownUser
+	}
+
+	var members []struct {
+		User discordUser `json:"user"`
+	}
+	q := url.Values{"query": {name}, "limit": {"10"}}
+	err := d.do("GET", "/guilds/"+d.guild+"/members/search?"+q.Encode(), nil, &members)
+	if err != nil {
+		return user{}, err
+	}
+
+	for _, m := range members {
+		if strings.EqualFold(m.User.Username, name) {
+			return user{discordPrefix + m.User.ID, m.User.Username}, nil
+		}
+	}
+	return user{}, errorUnknownUser
+}
+
+func (d *discord) do(method, path string, in interface{}, out interface{}) error {
+	header := http.Header{"Authorization": {"Bot " + d.token}}
+	return doJSON(method, d.api+path, header, in, out)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"./nebulas/crypto/utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key envelopes are stored hex encoded as version || nonce || AES-256-GCM(key).
+// The version byte is authenticated along with the ciphertext.
+const envelopeV1 byte = 1
+
+var errorUnexpectedLength = errors.New("unexpected length")
+var errorUnknownVersion = errors.New("unknown envelope version")
+
+// envelopeSalt is fixed so a secret always derives the same key; the secret itself
+// must stay private, the salt only separates this use from any other.
+var envelopeSalt = []byte("neby key envelope v1")
+
+var envelopeKeys = struct {
+	sync.Mutex
+	secret string
+	key    []byte
+}{}
+
+// envelopeKey derives the AES-256 key from the "secret" env var with scrypt.
+// The result is cached since scrypt is deliberately slow.
+func envelopeKey() ([]byte, error) {
+	secret := os.Getenv("secret")
+	if secret == "" {
+		return nil, errors.New("secret is not set")
+	}
+
+	envelopeKeys.Lock()
+	defer envelopeKeys.Unlock()
+
+	if envelopeKeys.key == nil || envelopeKeys.secret != secret {
+		key, err := scrypt.Key([]byte(secret), envelopeSalt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+		envelopeKeys.secret, envelopeKeys.key = secret, key
+	}
+
+	return envelopeKeys.key, nil
+}
+
+func envelopeCipher() (cipher.AEAD, error) {
+	key, err := envelopeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(bc)
+}
+
+func encrypt(acc account) (string, error) {
+	bytes, err := getPrivateKeyByteArray(acc)
+	if err != nil {
+		return "", err
+	}
+
+	return seal(bytes)
+}
+
+// seal wraps plain in a versioned envelope under a fresh random nonce.
+func seal(plain []byte) (string, error) {
+	aead, err := envelopeCipher()
+	if err != nil {
+		return "", err
+	}
+
+	header := append([]byte{envelopeV1}, utils.RandomCSPRNG(aead.NonceSize())...)
+	sealed := aead.Seal(header, header[1:], plain, header[:1])
+
+	return hex.EncodeToString(sealed), nil
+}
+
+func decrypt(d string) ([]byte, error) {
+	d = strings.Trim(d, `"`)
+
+	data, err := hex.DecodeString(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if isLegacyEnvelope(d) {
+		return decryptLegacy(data)
+	}
+
+	if len(data) == 0 {
+		return nil, errorUnexpectedLength
+	}
+
+	if data[0] != envelopeV1 {
+		return nil, errorUnknownVersion
+	}
+
+	aead, err := envelopeCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, errorUnexpectedLength
+	}
+
+	nonce := data[1 : 1+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[1+aead.NonceSize():], data[:1])
+}
+
+// isLegacyEnvelope reports whether d was written by the old unversioned format,
+// two AES-ECB blocks keyed directly with the secret.
+func isLegacyEnvelope(d string) bool {
+	return len(strings.Trim(d, `"`)) == 64
+}
+
+func decryptLegacy(data []byte) ([]byte, error) {
+	if len(data) != 32 {
+		return nil, errorUnexpectedLength
+	}
+
+	bc, err := aes.NewCipher([]byte(os.Getenv("secret")))
+	if err != nil {
+		return nil, err
+	}
+
+	var dst = make([]byte, 32)
+	for i := 0; i <= 32-16; i += 16 {
+		bc.Decrypt(dst[i:], data[i:])
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"./nebulas"
+	"./nebulas/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Status of an escrowed tip.
+const (
+	escrowHeld     = "held"
+	escrowClaimed  = "claimed"
+	escrowRefunded = "refunded"
+	// escrowFailed is a tip whose transaction into escrow didn't go through, there's nothing to pay out.
+	escrowFailed = "failed"
+	// escrowSending is a tip recorded before its transaction into escrow is sent.
+	escrowSending = "sending"
+)
+
+// escrowPeriod is how long a recipient has to claim a tip before it goes back to the sender,
+// set with the "escrowPeriod" env var, e.g. "72h".
+var escrowPeriod = loadEscrowPeriod(os.Getenv("escrowPeriod"))
+
+func loadEscrowPeriod(v string) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// escrowMinimum is the smallest tip the bot holds in escrow. The fee of paying an escrow out
+// comes off the tip rather than the bot's balance, so tiny tips would be all fee.
+var escrowMinimum = uint128(1000000000000000)
+
+var errorEscrowMinimum = fmt.Errorf("tips to someone who hasn't used the bot yet must be at least %v NAS", formatNAS(escrowMinimum))
+
+// escrow is a tip to someone who has never used the bot. Rather than creating a key for
+// them, the sender pays the bot's account, which pays the recipient once they claim it
+// or refunds the sender once the escrow expires. Either way the fee of that payout comes
+// off the tip.
+type escrow struct {
+	ID       uint64
+	Platform string
+	Waiter   waiter
+	// Hash is the transaction from the sender into escrow.
+	Hash    string
+	Expires time.Time
+	Status  string
+}
+
+// escrowWatcher refunds the tips that weren't claimed in time.
+type escrowWatcher struct {
+	interval time.Duration
+}
+
+var escrows = &escrowWatcher{time.Minute}
+
+func (s *stateStore) addEscrow(e *escrow) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(escrowsBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+}
+
+// setEscrowStatus moves escrow id from status from to status to, and reports false
+// if it wasn't in status from. Claims and refunds go through it so only one of them pays out.
+func (s *stateStore) setEscrowStatus(id uint64, from, to string) (e escrow, ok bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(escrowsBucket)
+		data := b.Get(seqKey(id))
+		if data == nil {
+			return nil
+		}
+		err := json.Unmarshal(data, &e)
+		if err != nil || e.Status != from {
+			return err
+		}
+
+		ok = true
+		e.Status = to
+		data, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+	return
+}
+
+// fundEscrow records hash as the transaction into escrow id, which then holds the tip.
+func (s *stateStore) fundEscrow(id uint64, hash string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(escrowsBucket)
+		var e escrow
+		data := b.Get(seqKey(id))
+		if data == nil {
+			return fmt.Errorf("no escrow %d", id)
+		}
+		err := json.Unmarshal(data, &e)
+		if err != nil {
+			return err
+		}
+
+		e.Hash, e.Status = hash, escrowHeld
+		data, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+}
+
+// heldEscrows returns the tips still waiting to be claimed.
+func (s *stateStore) heldEscrows() ([]escrow, error) {
+	var es []escrow
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(escrowsBucket).ForEach(func(k, v []byte) error {
+			var e escrow
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				return err
+			}
+			if e.Status == escrowHeld {
+				es = append(es, e)
+			}
+			return nil
+		})
+	})
+	return es, err
+}
+
+// hasAccount reports whether id has used the bot before, and so has a key of their own.
+func hasAccount(id string) (bool, error) {
+	_, err := keys.Get(id)
+	if err == errorNotInStorage {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// escrowTip records the tip w before it's paid into escrow, so that no tip reaches the
+// bot's account without a record to pay it out. fundEscrow completes it once it's sent.
+func escrowTip(p Platform, w waiter) (uint64, error) {
+	e := escrow{Platform: p.Name(), Waiter: w, Expires: time.Now().Add(escrowPeriod), Status: escrowSending}
+	err := state.addEscrow(&e)
+	return e.ID, err
+}
+
+// announceEscrow tells the recipient of an escrowed tip how to claim it, publicly too
+// since they may not accept DMs from the bot yet. Scheduled tips have no post to reply to.
+func announceEscrow(p Platform, w waiter, hash string) {
+	claim := fmt.Sprintf(`DM me "claim" within %v to receive it.`, formatPeriod(escrowPeriod))
+	if w.StatusID != "" {
+		err := p.Reply(w.StatusID, fmt.Sprintf("%v @%v sent %v NAS to @%v. %v TX: %v", reaction(), w.SenderName, amountNAS(w.Amount), w.RecipientName, claim, hash))
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	dm(p, w.RecipientID, fmt.Sprintf("@%v sent you %v NAS. %v", w.SenderName, amountNAS(w.Amount), claim))
+}
+
+// claimCmd pays out the tips held for userID.
+func claimCmd(p Platform, userID string) {
+	es, err := state.heldEscrows()
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+
+	claimed, pending := 0, 0
+	for _, e := range es {
+		if e.Waiter.RecipientID != userID {
+			continue
+		}
+		if status := escrowFunding(e); status != txSuccess {
+			if status == txPending {
+				pending++
+			}
+			continue
+		}
+		if _, ok, err := state.setEscrowStatus(e.ID, escrowHeld, escrowClaimed); err != nil || !ok {
+			continue
+		}
+
+		w := e.Waiter
+		hash, paid, err := payEscrow(p, w.RecipientID, w)
+		if err != nil {
+			fmt.Println(err)
+			state.setEscrowStatus(e.ID, escrowClaimed, escrowHeld)
+			dm(p, userID, fmt.Sprintf("Claiming %v NAS from @%v failed, try again later.\nReason: %v", amountNAS(w.Amount), w.SenderName, err))
+			continue
+		}
+
+		claimed++
+		trackTx(p, submittedTx{Hash: hash, SenderID: w.RecipientID}, ledgerEntry{
+			Kind:          ledgerClaim,
+			SenderName:    w.SenderName,
+			RecipientID:   w.RecipientID,
+			RecipientName: w.RecipientName,
+			Amount:        paid.String(),
+			StatusID:      w.StatusID,
+			Memo:          w.Memo,
+		})
+		dm(p, w.SenderID, fmt.Sprintf("@%v claimed the %v NAS you sent them.", w.RecipientName, amountNAS(w.Amount)))
+	}
+
+	if claimed == 0 && pending > 0 {
+		dm(p, userID, "Your tip is still being confirmed, try again in a minute.")
+	} else if claimed == 0 {
+		dm(p, userID, "There's nothing for you to claim.")
+	}
+}
+
+// escrowFunding returns the status of the sender's transaction into escrow. One the bot
+// stopped waiting for is looked up again, and counts as pending until it's executed.
+// Escrows whose transaction failed are marked failed, pending ones are left for later.
+func escrowFunding(e escrow) string {
+	t, ok, err := state.tx(e.Hash)
+	if err != nil {
+		fmt.Println("escrow", e.ID, "has no transaction:", err)
+		return txUnknown
+	}
+	if !ok {
+		t = submittedTx{Hash: e.Hash, Status: txUnknown}
+	}
+	if t.Status == txUnknown {
+		t.Status = tracker.recheck(t)
+	}
+	if t.Status == txFailed {
+		// Never pay out what didn't arrive.
+		state.setEscrowStatus(e.ID, escrowHeld, escrowFailed)
+	}
+	return t.Status
+}
+
+// payEscrow sends the tip w from the bot's account to userID's account, less the fee of
+// sending it, and returns what was paid.
+func payEscrow(p Platform, userID string, w waiter) (string, *util.Uint128, error) {
+	acc, err := getAcc(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	watch(p, userID, acc)
+
+	amount, err := util.NewUint128FromString(w.Amount)
+	if err != nil {
+		return "", nil, err
+	}
+	return submitLessFee(bot, acc.addr, amount, core.TxPayloadBinaryType, nil)
+}
+
+func (w *escrowWatcher) run(ps []Platform) {
+	byName := map[string]Platform{}
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+
+	for {
+		err := w.refund(byName, time.Now())
+		if err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// refund sends the tips that expired by now back to their senders.
+func (w *escrowWatcher) refund(byName map[string]Platform, now time.Time) error {
+	es, err := state.heldEscrows()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range es {
+		p, ok := byName[e.Platform]
+		if !ok || now.Before(e.Expires) || escrowFunding(e) != txSuccess {
+			continue
+		}
+		if _, ok, err := state.setEscrowStatus(e.ID, escrowHeld, escrowRefunded); err != nil || !ok {
+			continue
+		}
+
+		t := e.Waiter
+		hash, paid, err := payEscrow(p, t.SenderID, t)
+		if err != nil {
+			// Try again next time.
+			fmt.Println(err)
+			state.setEscrowStatus(e.ID, escrowRefunded, escrowHeld)
+			continue
+		}
+
+		err = state.addLedger(&ledgerEntry{
+			Kind:          ledgerExpiry,
+			Platform:      e.Platform,
+			SenderID:      t.SenderID,
+			SenderName:    t.SenderName,
+			RecipientName: t.RecipientName,
+			Amount:        t.Amount,
+			Hash:          e.Hash,
+			StatusID:      t.StatusID,
+			Memo:          t.Memo,
+			Status:        escrowRefunded,
+			Created:       now,
+			Updated:       now,
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+		dm(p, t.SenderID, fmt.Sprintf("@%v didn't claim the %v NAS you sent them within %v, so I'm sending back %v NAS after fees.", t.RecipientName, amountNAS(t.Amount), formatPeriod(escrowPeriod), formatNAS(paid)))
+		trackTx(p, submittedTx{Hash: hash, SenderID: t.SenderID}, ledgerEntry{
+			Kind:          ledgerRefund,
+			SenderName:    t.RecipientName,
+			RecipientID:   t.SenderID,
+			RecipientName: t.SenderName,
+			Amount:        paid.String(),
+			StatusID:      t.StatusID,
+			Memo:          t.Memo,
+		})
+	}
+	return nil
+}
+
+// formatPeriod writes whole days as days and whole hours as hours, "7 days" reads better than "168h0m0s".
+func formatPeriod(d time.Duration) string {
+	switch {
+	case d == 24*time.Hour:
+		return "1 day"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Minute:
+		return "1 minute"
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
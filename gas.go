@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"./nebulas"
+	"./nebulas/util"
+)
+
+// gasMargin is the percentage added on top of the node's gas estimate, set with the "gasMargin" env var.
+var gasMargin = loadGasMargin(os.Getenv("gasMargin"))
+
+func loadGasMargin(v string) uint64 {
+	margin, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 20
+	}
+	return margin
+}
+
+// estimateFee asks the node for the gas price and the gas the transaction will use,
+// and returns them with the safety margin applied and clamped to the protocol limits.
+func estimateFee(from *core.Address, to *core.Address, value *util.Uint128, nonce uint64, txtype string, payload []byte) (price *util.Uint128, limit *util.Uint128, err error) {
+	price, err = node.gasPrice()
+	if err != nil {
+		return
+	}
+	price = clampGas(price, core.TransactionGasPrice, core.TransactionMaxGasPrice)
+
+	req := callRequest{
+		From:     from.String(),
+		To:       to.String(),
+		Value:    value.String(),
+		Nonce:    nonce,
+		GasPrice: price.String(),
+		GasLimit: core.TransactionMaxGas.String(),
+		Type:     txtype,
+	}
+	if txtype == core.TxPayloadCallType {
+		req.Contract = &callContract{}
+		err = json.Unmarshal(payload, req.Contract)
+		if err != nil {
+			return
+		}
+	} else {
+		req.Binary = payload
+	}
+
+	est, err := node.estimateGas(req)
+	if err != nil {
+		return
+	}
+	if est.Err != "" {
+		err = errors.New(est.Err)
+		return
+	}
+
+	gas, err := util.NewUint128FromString(est.Gas)
+	if err != nil {
+		err = errorDecodeJSON
+		return
+	}
+
+	limit, err = withMargin(gas, gasMargin)
+	if err != nil {
+		return
+	}
+	limit = clampGas(limit, core.MinGasCountPerTransaction, core.TransactionMaxGas)
+	return
+}
+
+// estimateNext is estimateFee for the next transaction from, with the nonce the nonce
+// manager would give it.
+func estimateNext(from *core.Address, to *core.Address, value *util.Uint128, txtype string, payload []byte) (price *util.Uint128, limit *util.Uint128, err error) {
+	nonce, err := nonces.peek(from)
+	if err != nil {
+		return
+	}
+	return estimateFee(from, to, value, nonce, txtype, payload)
+}
+
+// lessFee returns what's left of value once the maximum fee is taken off.
+func lessFee(value, price, limit *util.Uint128) (*util.Uint128, error) {
+	fee, err := price.Mul(limit)
+	if err != nil {
+		return nil, core.ErrGasFeeOverflow
+	}
+	if fee.Cmp(value) >= 0 {
+		return nil, fmt.Errorf("the fee of %v NAS is more than the amount", formatNAS(fee))
+	}
+	return value.Sub(fee)
+}
+
+// withMargin returns gas increased by percent.
+func withMargin(gas *util.Uint128, percent uint64) (*util.Uint128, error) {
+	scaled, err := gas.Mul(uint128(100 + percent))
+	if err != nil {
+		return nil, err
+	}
+	return scaled.Div(uint128(100))
+}
+
+// clampGas keeps v within [min, max]. A zero v falls back to min.
+func clampGas(v, min, max *util.Uint128) *util.Uint128 {
+	if v.Cmp(min) < 0 {
+		return min
+	}
+	if v.Cmp(max) > 0 {
+		return max
+	}
+	return v
+}
+
+// balanceError is a transfer that addr can't pay for.
+type balanceError struct {
+	need *util.Uint128
+	have *util.Uint128
+}
+
+func (e *balanceError) Error() string {
+	return fmt.Sprintf("%v: need %v wei including fees, have %v", core.ErrInsufficientBalance, e.need, e.have)
+}
+
+// checkBalance refuses a transfer whose value plus the maximum fee is more than addr holds.
+func checkBalance(addr *core.Address, value, price, limit *util.Uint128) error {
+	state, err := node.accountState(addr)
+	if err != nil {
+		return err
+	}
+
+	fee, err := price.Mul(limit)
+	if err != nil {
+		return core.ErrGasFeeOverflow
+	}
+
+	total, err := value.Add(fee)
+	if err != nil {
+		return core.ErrInvalidTransfer
+	}
+
+	if total.Cmp(state.Balance) > 0 {
+		return &balanceError{total, state.Balance}
+	}
+	return nil
+}
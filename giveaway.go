@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"./nebulas/crypto/keystore/secp256k1"
+	"./nebulas/crypto/keystore/secp256k1/vrf/secp256k1VRF"
+	"./nebulas/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+var errorInvalidVRFKey = errors.New("invalid VRF public key")
+
+// Status of a giveaway.
+const (
+	giveawayOpen  = "open"
+	giveawayDrawn = "drawn"
+	// giveawayCancelled is a giveaway nobody entered, or whose sender couldn't pay it.
+	giveawayCancelled = "cancelled"
+)
+
+// blockInterval is the time between two Nebulas blocks.
+const blockInterval = 15 * time.Second
+
+// replyMaxLen is the length of the replies the entrants are published in, short enough for every platform.
+const replyMaxLen = 280
+
+// giveawayPeriod is how long a giveaway takes entries, set with the "giveawayPeriod" env var, e.g. "24h".
+var giveawayPeriod = loadGiveawayPeriod(os.Getenv("giveawayPeriod"))
+
+func loadGiveawayPeriod(v string) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil || d < blockInterval {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// giveaway shares an amount between winners drawn among the users who replied to a post
+// before its closing block. The draw is the VRF of the bot's key over the post's ID, the
+// hash of the closing block, which nobody knows while the giveaway takes entries, and the
+// hash of the entrants. The bot publishes the entrants and the proof, so anyone can check
+// the winners with verify-giveaway.
+type giveaway struct {
+	ID       uint64
+	Platform string
+	// Waiter has the sender, the total amount and the post to reply to, and no recipient.
+	Waiter      waiter
+	Winners     int
+	CloseHeight uint64
+	Status      string
+	// BlockHash, Entrants, Proof and Drawn are set once the giveaway is drawn.
+	BlockHash string `json:",omitempty"`
+	Entrants  []user `json:",omitempty"`
+	// Proof is the hex encoded VRF proof.
+	Proof string `json:",omitempty"`
+	Drawn []user `json:",omitempty"`
+}
+
+// giveawayWatcher draws the giveaways whose closing block has been produced.
+type giveawayWatcher struct {
+	node     *rpcClient
+	interval time.Duration
+}
+
+var giveaways = &giveawayWatcher{node, time.Minute}
+
+func (s *stateStore) addGiveaway(g *giveaway) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(giveawaysBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		g.ID = id
+
+		data, err := json.Marshal(g)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+}
+
+func (s *stateStore) putGiveaway(g giveaway) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(giveawaysBucket).Put(seqKey(g.ID), data)
+	})
+}
+
+// openGiveaways returns the giveaways still taking entries.
+func (s *stateStore) openGiveaways() ([]giveaway, error) {
+	var gs []giveaway
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(giveawaysBucket).ForEach(func(k, v []byte) error {
+			var g giveaway
+			err := json.Unmarshal(v, &g)
+			if err != nil {
+				return err
+			}
+			if g.Status == giveawayOpen {
+				gs = append(gs, g)
+			}
+			return nil
+		})
+	})
+	return gs, err
+}
+
+// giveawaySeed is the message the VRF is evaluated over. The entrants are in it so a proof
+// only checks out with the list they were drawn from.
+func giveawaySeed(statusID, blockHash string, entrants []string) []byte {
+	sorted := append([]string(nil), entrants...)
+	sort.Strings(sorted)
+	h := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return []byte(fmt.Sprintf("%v:%v:%x", statusID, blockHash, h))
+}
+
+// pickWinners draws n of the entrants with the VRF output index. Entrants are sorted first,
+// then the i-th winner is the SHA-256 of index and i modulo the number of entrants left,
+// so the same index and entrants always give the same winners.
+func pickWinners(index [32]byte, entrants []string, n int) []string {
+	left := append([]string(nil), entrants...)
+	sort.Strings(left)
+
+	var winners []string
+	for i := 0; i < n && len(left) > 0; i++ {
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], uint32(i))
+		h := sha256.Sum256(append(index[:], counter[:]...))
+		k := new(big.Int).Mod(new(big.Int).SetBytes(h[:]), big.NewInt(int64(len(left)))).Int64()
+
+		winners = append(winners, left[k])
+		left = append(left[:k], left[k+1:]...)
+	}
+	return winners
+}
+
+// drawGiveaway evaluates the VRF of acc over the seed and picks n winners among entrants.
+func drawGiveaway(acc account, statusID, blockHash string, entrants []string, n int) (winners []string, proof []byte, err error) {
+	priv, err := getPrivateKeyByteArray(acc)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := secp256k1VRF.NewVRFSignerFromRawKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index, proof := k.Evaluate(giveawaySeed(statusID, blockHash, entrants))
+	if len(proof) == 0 {
+		return nil, nil, secp256k1VRF.ErrEvaluateFailed
+	}
+	return pickWinners(index, entrants, n), proof, nil
+}
+
+// verifyGiveaway checks proof against the public key pub and returns the winners it draws.
+func verifyGiveaway(pub []byte, statusID, blockHash string, proof []byte, entrants []string, n int) ([]string, error) {
+	if x, _ := elliptic.Unmarshal(secp256k1.S256(), pub); x == nil {
+		return nil, errorInvalidVRFKey
+	}
+	v, err := secp256k1VRF.NewVRFVerifierFromRawKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := v.ProofToHash(giveawaySeed(statusID, blockHash, entrants), proof)
+	if err != nil {
+		return nil, err
+	}
+	return pickWinners(index, entrants, n), nil
+}
+
+// askGiveaway asks the sender of m to confirm the giveaway cmd among the replies to m.
+func askGiveaway(p Platform, m mention, cmd giveawayCmd) {
+	if _, ok := p.(replyLister); !ok {
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I can't list replies on %v, so I can't run giveaways there.", p.Name()))
+		return
+	}
+	if cmd.Winners > rainMaxRecipients {
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, a giveaway can have at most %d winners.", rainMaxRecipients))
+		return
+	}
+	if _, err := splitAmount(cmd.Amount, cmd.Winners); err != nil {
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I can't split that: %v.", err))
+		return
+	}
+
+	if refuseSpend(p, m.Sender.ID, cmd.Amount) {
+		return
+	}
+
+	w := waiter{m.StatusID, m.Sender.ID, m.Sender.Name, "", "", cmd.Amount.String(), cmd.Memo}
+	g := giveaway{Platform: p.Name(), Waiter: w, Winners: cmd.Winners}
+	c := confirmation{Platform: p.Name(), Waiter: w, Deadline: time.Now().Add(confirmTimeout), Giveaway: &g}
+	askConfirmation(p, c, fmt.Sprintf("CONFIRMATION: Give away %v NAS to %v among the replies to your post? (yes/NO)", formatNAS(cmd.Amount), winnersText(cmd.Winners)))
+}
+
+// openGiveaway starts taking entries for the confirmed giveaway c. It closes at the block
+// produced about giveawayPeriod from now.
+func openGiveaway(p Platform, c confirmation) {
+	g := *c.Giveaway
+	w := g.Waiter
+
+	err := checkRainBalance(w.SenderID, w.Amount, g.Winners)
+	if err == nil {
+		g.CloseHeight, err = node.height()
+	}
+	if err == nil {
+		g.CloseHeight += uint64(giveawayPeriod / blockInterval)
+		g.Status = giveawayOpen
+		err = state.addGiveaway(&g)
+	}
+	if err != nil {
+		dm(p, w.SenderID, fmt.Sprintf("Giveaway not started.\nReason: %v", err))
+		return
+	}
+
+	dm(p, w.SenderID, fmt.Sprintf("Your giveaway is open until block %d, about %v from now.", g.CloseHeight, formatPeriod(giveawayPeriod)))
+	err = p.Reply(w.StatusID, fmt.Sprintf("@%v is giving away %v NAS to %v! Reply to this post before block %d to enter. The draw is a VRF over this post's ID, the hash of that block and the entrants, anyone can check it.", w.SenderName, amountNAS(w.Amount), winnersText(g.Winners), g.CloseHeight))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (gw *giveawayWatcher) run(ps []Platform) {
+	byName := map[string]Platform{}
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+
+	for {
+		err := gw.draw(byName)
+		if err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(gw.interval)
+	}
+}
+
+// draw closes the open giveaways whose closing block is on the chain.
+func (gw *giveawayWatcher) draw(byName map[string]Platform) error {
+	tail, err := gw.node.height()
+	if err != nil {
+		return err
+	}
+
+	gs, err := state.openGiveaways()
+	if err != nil {
+		return err
+	}
+	for _, g := range gs {
+		p, ok := byName[g.Platform]
+		if !ok || g.CloseHeight > tail {
+			continue
+		}
+		err := gw.close(p, g)
+		if err != nil {
+			// Try again next time.
+			fmt.Println("giveaway", g.ID, "wasn't drawn:", err)
+		}
+	}
+	return nil
+}
+
+// close draws the winners of g, pays them their share and publishes the draw.
+func (gw *giveawayWatcher) close(p Platform, g giveaway) error {
+	w := g.Waiter
+	b, err := gw.node.blockByHeight(g.CloseHeight)
+	if err != nil {
+		return err
+	}
+	closed, err := b.time()
+	if err != nil {
+		return err
+	}
+	replies, err := p.(replyLister).Replies(w.StatusID)
+	truncated := err == errorRepliesTruncated
+	if err != nil && !truncated {
+		return err
+	}
+
+	// Everyone gets one entry, however often they replied, and the sender can't win.
+	// Replies after the closing block don't count, its hash was public by then.
+	var ids []string
+	byID := map[string]user{w.SenderID: {}}
+	for _, r := range replies {
+		u := r.Author
+		if r.Posted.After(closed) {
+			continue
+		}
+		if _, ok := byID[u.ID]; u.ID != "" && !ok {
+			byID[u.ID] = u
+			ids = append(ids, u.ID)
+			g.Entrants = append(g.Entrants, u)
+		}
+	}
+
+	if len(ids) == 0 {
+		g.Status = giveawayCancelled
+		dm(p, w.SenderID, "Nobody entered your giveaway, nothing was sent.")
+		return state.putGiveaway(g)
+	}
+	// One transfer per winner, there can be fewer entrants than winners.
+	transfers := g.Winners
+	if len(ids) < transfers {
+		transfers = len(ids)
+	}
+	if err := checkRainBalance(w.SenderID, w.Amount, transfers); err != nil {
+		g.Status = giveawayCancelled
+		dm(p, w.SenderID, fmt.Sprintf("Your giveaway was cancelled.\nReason: %v", err))
+		return state.putGiveaway(g)
+	}
+
+	winners, proof, err := drawGiveaway(bot, w.StatusID, b.Hash, ids, g.Winners)
+	if err != nil {
+		return err
+	}
+	pub, err := bot.priv.PublicKey().Encoded()
+	if err != nil {
+		return err
+	}
+	amount, err := util.NewUint128FromString(w.Amount)
+	if err != nil {
+		return err
+	}
+	shares, err := splitAmount(amount, len(winners))
+	if err != nil {
+		return err
+	}
+
+	g.Status, g.BlockHash, g.Proof = giveawayDrawn, b.Hash, fmt.Sprintf("%x", proof)
+	ws := make([]waiter, len(winners))
+	for i, id := range winners {
+		u := byID[id]
+		g.Drawn = append(g.Drawn, u)
+		ws[i] = waiter{w.StatusID, w.SenderID, w.SenderName, u.ID, u.Name, shares[i].String(), w.Memo}
+	}
+	// Recorded before paying, so a restart can't draw and pay the giveaway twice.
+	err = state.putGiveaway(g)
+	if err != nil {
+		return err
+	}
+
+	lines, paid := sendShares(p, ws)
+	summary := fmt.Sprintf("Giveaway of %v NAS: %d of %d transfers sent.\n", amountNAS(w.Amount), len(paid), len(ws))
+	dm(p, w.SenderID, summary+strings.Join(lines, "\n"))
+
+	result := fmt.Sprintf("%v %v won the giveaway of @%v, drawn among %d entries at block %d!", reaction(), joinNames(userNames(g.Drawn)), w.SenderName, len(ids), g.CloseHeight)
+	if truncated {
+		result += " I couldn't list every reply, some entries may be missing."
+	}
+	texts := []string{
+		result,
+		"VRF proof: " + g.Proof,
+		fmt.Sprintf("VRF key: %x", pub),
+	}
+	for _, text := range append(texts, entrantsReplies(ids)...) {
+		err = p.Reply(w.StatusID, text)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+// entrantsReplies writes the sorted IDs of the entrants in as few replies of at most
+// replyMaxLen characters as it takes, for verify-giveaway.
+func entrantsReplies(ids []string) []string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+
+	var texts []string
+	text := "Entrants:"
+	for _, id := range sorted {
+		if len(text)+1+len(id) > replyMaxLen {
+			texts = append(texts, text)
+			text = "Entrants:"
+		}
+		text += " " + id
+	}
+	return append(texts, text)
+}
+
+// winnersText writes "1 winner" or "3 winners".
+func winnersText(n int) string {
+	if n == 1 {
+		return "1 winner"
+	}
+	return fmt.Sprintf("%d winners", n)
+}
+
+func userNames(us []user) []string {
+	names := make([]string, len(us))
+	for i, u := range us {
+		names[i] = "@" + u.Name
+	}
+	return names
+}
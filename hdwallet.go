@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"./nebulas/crypto/keystore/secp256k1"
+	"github.com/tyler-smith/go-bip39"
+)
+
+var errorInvalidMnemonic = errors.New("invalid mnemonic")
+var errorHDNotStored = errors.New("hd wallet keys are derived, not stored")
+
+// hdStore derives the key of every user from one BIP39 mnemonic, so a backup
+// of the mnemonic is enough to recover all accounts.
+type hdStore struct {
+	account *secp256k1.ExtendedKey
+}
+
+func newHDStore(mnemonic, passphrase string) (*hdStore, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errorInvalidMnemonic
+	}
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := secp256k1.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// m/44'/2718'/0'/0, the external chain of the first BIP44 account.
+	h := secp256k1.HardenedKeyStart
+	account, err := master.Derive(h+44, h+secp256k1.NebulasCoinType, h, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hdStore{account}, nil
+}
+
+// userPath maps a platform user ID to the rest of its derivation path. IDs don't fit
+// in one index, so three 31 bit indices are taken from the ID's hash. They are hardened:
+// with normal ones, an exported user key and the account's public chain code would give
+// away the account key, and with it the key of every other user.
+func userPath(id string) []uint32 {
+	sum := sha256.Sum256([]byte(id))
+	path := make([]uint32, 3)
+	for i := range path {
+		path[i] = binary.BigEndian.Uint32(sum[i*4:]) | secp256k1.HardenedKeyStart
+	}
+	return path
+}
+
+func (s *hdStore) Get(id string) (account, error) {
+	k, err := s.account.Derive(userPath(id)...)
+	if err != nil {
+		return account{}, err
+	}
+
+	priv, err := k.PrivateKey().Encoded()
+	if err != nil {
+		return account{}, err
+	}
+	return newAccount(priv)
+}
+
+// Put only accepts the account Get would derive for id anyway.
+func (s *hdStore) Put(id string, acc account) error {
+	derived, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if !derived.addr.Equals(acc.addr) {
+		return errorHDNotStored
+	}
+	return nil
+}
+
+func (s *hdStore) List() ([]string, error) {
+	return nil, errorHDNotStored
+}
+
+func (s *hdStore) Rotate(id string) error {
+	return nil
+}
+
+// newMnemonic returns a fresh 24 word BIP39 mnemonic.
+func newMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(256)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 )
@@ -23,3 +24,56 @@ func readBody(resp *http.Response) (body []byte) {
 
 	return
 }
+
+// httpError is returned by doJSON when a chat platform answers with a non-2xx status.
+type httpError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%v returned %d: %v", e.URL, e.StatusCode, e.Body)
+}
+
+// doJSON sends in as a JSON body and decodes the response into out, either may be nil.
+func doJSON(method, url string, header http.Header, in interface{}, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data := readBody(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpError{url, resp.StatusCode, string(data)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return errorDecodeJSON
+	}
+	return nil
+}
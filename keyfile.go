@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"./nebulas"
+	"./nebulas/crypto"
+	"./nebulas/crypto/cipher"
+	"./nebulas/crypto/keystore"
+	"./nebulas/crypto/utils"
+)
+
+var errorKeyFileAddress = errors.New("key file address doesn't match its key")
+
+// keyFileScrypt is the scrypt cost used for exported key files.
+var keyFileScrypt = &cipher.Scrypt{}
+
+// keyFile is a V3 key file as written by the Nebulas and Ethereum wallets.
+type keyFile struct {
+	Version int               `json:"version"`
+	ID      string            `json:"id"`
+	Address string            `json:"address"`
+	Crypto  cipher.CryptoJSON `json:"crypto"`
+}
+
+// exportKeyFile encrypts the private key of acc with passphrase into a V3 key file.
+func exportKeyFile(acc account, passphrase string) ([]byte, error) {
+	priv, err := getPrivateKeyByteArray(acc)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := keyFileScrypt.EncryptJSON(priv, []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(keyFile{3, newUUID(), acc.addr.String(), *c})
+}
+
+// importKeyFile decrypts a V3 key file, or a Nebulas version 4 one, with passphrase.
+func importKeyFile(data []byte, passphrase string) (keystore.PrivateKey, error) {
+	var kf keyFile
+	err := json.Unmarshal(data, &kf)
+	if err != nil {
+		return nil, err
+	}
+
+	if kf.Version != 3 && kf.Version != 4 {
+		return nil, cipher.ErrVersionInvalid
+	}
+
+	key, err := new(cipher.Scrypt).DecryptJSON(&kf.Crypto, []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := crypto.NewPrivateKey(keystore.SECP256K1, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ethereum wallets write a hex address, only a Nebulas one can be checked against the key.
+	if want, err := core.AddressParse(kf.Address); err == nil {
+		pub, err := priv.PublicKey().Encoded()
+		if err != nil {
+			return nil, err
+		}
+
+		addr, err := core.NewAddressFromPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+
+		if !addr.Equals(want) {
+			return nil, errorKeyFileAddress
+		}
+	}
+
+	return priv, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := utils.RandomCSPRNG(16)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
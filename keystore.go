@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// KeyStore holds the custodial key of every user, keyed by their platform user ID.
+// Get returns errorNotInStorage for users that don't have a key yet.
+type KeyStore interface {
+	Get(id string) (account, error)
+	Put(id string, acc account) error
+	List() ([]string, error)
+	// Rotate re-encrypts the stored key of id under the current envelope and secret.
+	Rotate(id string) error
+}
+
+var keys, errorKeyStore = loadKeyStore(os.Getenv)
+
+// loadKeyStore picks the backend named by the "keyStore" env var: contract (default), file, hd or memory.
+// The file store is kept at "keyFile", the hd store derives keys from "mnemonic" and "mnemonicPassphrase".
+func loadKeyStore(getenv func(string) string) (KeyStore, error) {
+	switch name := getenv("keyStore"); name {
+	case "", "contract":
+		return &contractStore{}, nil
+	case "file":
+		path := getenv("keyFile")
+		if path == "" {
+			path = "keys.json"
+		}
+		return newFileStore(path)
+	case "hd":
+		return newHDStore(getenv("mnemonic"), getenv("mnemonicPassphrase"))
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown key store %q", name)
+	}
+}
+
+// memoryStore keeps keys in process memory, for tests and throwaway runs.
+type memoryStore struct {
+	mu   sync.Mutex
+	accs map[string]account
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{accs: map[string]account{}}
+}
+
+func (m *memoryStore) Get(id string) (account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accs[id]
+	if !ok {
+		return account{}, errorNotInStorage
+	}
+	return acc, nil
+}
+
+func (m *memoryStore) Put(id string, acc account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accs[id] = acc
+	return nil
+}
+
+func (m *memoryStore) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.accs))
+	for id := range m.accs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *memoryStore) Rotate(id string) error {
+	_, err := m.Get(id)
+	return err
+}
+
+// fileStore keeps key envelopes in a local JSON file, rewritten on every change.
+type fileStore struct {
+	mu        sync.Mutex
+	path      string
+	envelopes map[string]string
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	f := &fileStore{path: path, envelopes: map[string]string{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &f.envelopes)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileStore) Get(id string) (account, error) {
+	f.mu.Lock()
+	enc, ok := f.envelopes[id]
+	f.mu.Unlock()
+	if !ok {
+		return account{}, errorNotInStorage
+	}
+
+	key, err := decrypt(enc)
+	if err != nil {
+		return account{}, err
+	}
+	return newAccount(key)
+}
+
+func (f *fileStore) Put(id string, acc account) error {
+	enc, err := encrypt(acc)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.envelopes[id] = enc
+	return f.save()
+}
+
+func (f *fileStore) List() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]string, 0, len(f.envelopes))
+	for id := range f.envelopes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (f *fileStore) Rotate(id string) error {
+	acc, err := f.Get(id)
+	if err != nil {
+		return err
+	}
+	return f.Put(id, acc)
+}
+
+// save writes the envelopes to a temporary file and renames it over the old one,
+// so a crash never leaves a half written key file behind.
+func (f *fileStore) save() error {
+	data, err := json.MarshalIndent(f.envelopes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
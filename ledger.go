@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kind of a ledger entry.
+const (
+	ledgerTip      = "tip"
+	ledgerTransfer = "transfer"
+	// ledgerEscrow is a tip paid into escrow for a recipient who hasn't used the bot yet.
+	ledgerEscrow = "escrow"
+	// ledgerClaim pays an escrowed tip out to its recipient.
+	ledgerClaim = "claim"
+	// ledgerExpiry records that an escrowed tip wasn't claimed in time, no money moves.
+	ledgerExpiry = "expiry"
+	// ledgerRefund pays an expired escrowed tip back to its sender.
+	ledgerRefund = "refund"
+)
+
+// historyPageSize is the number of entries the history command shows at once.
+const historyPageSize = 10
+
+// ledgerEntry records a tip or transfer the bot submitted on behalf of a user.
+// Sender and recipient follow the money, claims and refunds are paid by the bot's
+// account so they only have a SenderName, to say whose tip they are about.
+type ledgerEntry struct {
+	Seq           uint64
+	Kind          string
+	Platform      string
+	SenderID      string
+	SenderName    string
+	RecipientID   string `json:",omitempty"`
+	RecipientName string `json:",omitempty"`
+	// To is the address a transfer was sent to.
+	To string `json:",omitempty"`
+	// Amount is in wei.
+	Amount string
+	Hash   string
+	// StatusID is the platform's ID of the mention that started a tip.
+	StatusID string `json:",omitempty"`
+	Memo     string `json:",omitempty"`
+	Status   string
+	Created  time.Time
+	Updated  time.Time
+}
+
+// counterparty describes the other side of the entry as seen by userID.
+func (e ledgerEntry) counterparty(userID string) (direction string, other string) {
+	if e.Kind == ledgerExpiry {
+		return "expired", "@" + e.RecipientName
+	}
+	if e.SenderID == userID {
+		direction = "sent"
+		other = e.To
+		if e.RecipientName != "" {
+			other = "@" + e.RecipientName
+		}
+		return
+	}
+	return "received", "@" + e.SenderName
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// addLedger stores e under a new sequence number and indexes it for its sender and recipient.
+func (s *stateStore) addLedger(e *ledgerEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ledgerBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.Seq = seq
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		err = b.Put(seqKey(seq), data)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range []string{e.SenderID, e.RecipientID} {
+			if id == "" {
+				continue
+			}
+			u, err := tx.Bucket(ledgerUsersBucket).CreateBucketIfNotExists([]byte(id))
+			if err != nil {
+				return err
+			}
+			err = u.Put(seqKey(seq), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setLedgerStatus records the receipt status of the transaction of entry seq.
+func (s *stateStore) setLedgerStatus(seq uint64, status string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ledgerBucket)
+		var e ledgerEntry
+		data := b.Get(seqKey(seq))
+		if data == nil {
+			return nil
+		}
+		err := json.Unmarshal(data, &e)
+		if err != nil {
+			return err
+		}
+
+		e.Status = status
+		e.Updated = time.Now()
+		data, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(seq), data)
+	})
+}
+
+// ledger returns up to limit entries of userID, newest first, skipping the newest offset,
+// and the number of entries userID has in total. A limit of 0 returns all of them.
+func (s *stateStore) ledger(userID string, offset, limit int) (entries []ledgerEntry, total int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		u := tx.Bucket(ledgerUsersBucket).Bucket([]byte(userID))
+		if u == nil {
+			return nil
+		}
+		total = u.Stats().KeyN
+
+		b := tx.Bucket(ledgerBucket)
+		c := u.Cursor()
+		i := 0
+		for k, _ := c.Last(); k != nil && (limit == 0 || len(entries) < limit); k, _ = c.Prev() {
+			if i++; i <= offset {
+				continue
+			}
+			var e ledgerEntry
+			err := json.Unmarshal(b.Get(k), &e)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return
+}
+
+// historyCmd answers "history [page]" with a page of the user's ledger.
+func historyCmd(p Platform, userID string, page int) {
+	entries, total, err := state.ledger(userID, (page-1)*historyPageSize, historyPageSize)
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+	if total == 0 {
+		dm(p, userID, "You haven't sent or received anything through me yet.")
+		return
+	}
+	pages := (total + historyPageSize - 1) / historyPageSize
+	if len(entries) == 0 {
+		dm(p, userID, fmt.Sprintf("There are only %d pages.", pages))
+		return
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		direction, other := e.counterparty(userID)
+		preposition := "to"
+		switch direction {
+		case "received":
+			preposition = "from"
+		case "expired":
+			direction, preposition = "unclaimed", "for"
+		}
+		status := e.Status
+		if e.Kind != ledgerTip && e.Kind != ledgerTransfer {
+			status = e.Kind + ", " + status
+		}
+		fmt.Fprintf(&b, "%v %v %v NAS %v %v (%v) TX: %v\n", e.Created.UTC().Format("2006-01-02 15:04"), direction, amountNAS(e.Amount), preposition, other, status, e.Hash)
+	}
+	fmt.Fprintf(&b, "Page %d of %d.", page, pages)
+	if page < pages {
+		fmt.Fprintf(&b, ` Type "history %d" for older entries.`, page+1)
+	}
+	dm(p, userID, b.String())
+}
+
+// exportHistoryCmd DMs the user's whole ledger as CSV.
+func exportHistoryCmd(p Platform, userID string) {
+	entries, _, err := state.ledger(userID, 0, 0)
+	var buf bytes.Buffer
+	if err == nil {
+		err = writeLedgerCSV(&buf, userID, entries)
+	}
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+	if len(entries) == 0 {
+		dm(p, userID, "You haven't sent or received anything through me yet.")
+		return
+	}
+
+	dm(p, userID, "Save the following as a .csv file:")
+	dm(p, userID, buf.String())
+}
+
+func writeLedgerCSV(buf *bytes.Buffer, userID string, entries []ledgerEntry) error {
+	w := csv.NewWriter(buf)
+	w.Write([]string{"time", "kind", "direction", "counterparty", "amount_nas", "tx_hash", "status", "platform", "status_id", "memo"})
+	for _, e := range entries {
+		direction, other := e.counterparty(userID)
+		w.Write([]string{e.Created.UTC().Format(time.RFC3339), e.Kind, direction, other, amountNAS(e.Amount), e.Hash, e.Status, e.Platform, e.StatusID, e.Memo})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"./nebulas/util"
+)
+
+// limits caps what a user sends. Amounts are in wei, empty means no limit.
+type limits struct {
+	// PerTx caps a single tip, transfer, rain or giveaway.
+	PerTx string `json:",omitempty"`
+	// Daily caps what a user sends in 24 hours.
+	Daily string `json:",omitempty"`
+	// Code is the amount above which the sender has to type back a one-time code to confirm.
+	Code string `json:",omitempty"`
+}
+
+// limitNames maps the name of each limit to its field.
+var limitNames = map[string]func(l *limits) *string{
+	"tx":    func(l *limits) *string { return &l.PerTx },
+	"daily": func(l *limits) *string { return &l.Daily },
+	"code":  func(l *limits) *string { return &l.Code },
+}
+
+// ownLimits are the limits a user set for themselves. Raising them only takes effect
+// after limitRaiseDelay, so someone who takes over a user's chat account can't lift
+// them straight away.
+type ownLimits struct {
+	limits
+	// Raised replaces the limits from RaisedFrom on.
+	Raised     *limits   `json:",omitempty"`
+	RaisedFrom time.Time `json:",omitempty"`
+}
+
+// limitRaiseDelay is how long users wait for limits they raised.
+const limitRaiseDelay = 24 * time.Hour
+
+// codeDigits is the length of the one-time codes that confirm large amounts.
+const codeDigits = 6
+
+var botLimits, errorLimits = loadLimits(os.Getenv)
+
+// loadLimits reads the limits of every user from the "txLimit", "dailyLimit" and "codeAbove"
+// env vars, amounts like "100 NAS". Users can only lower them.
+func loadLimits(getenv func(string) string) (limits, error) {
+	var l limits
+	for name, env := range map[string]string{"tx": "txLimit", "daily": "dailyLimit", "code": "codeAbove"} {
+		v := getenv(env)
+		if v == "" {
+			continue
+		}
+		amount, err := parseNAS(v)
+		if err != nil {
+			return limits{}, fmt.Errorf("invalid %v %q: %v", env, v, err)
+		}
+		*limitNames[name](&l) = amount.String()
+	}
+	return l, nil
+}
+
+// lowerLimit returns the lower of the limits a and b.
+func lowerLimit(a, b string) string {
+	if a == "" || b != "" && over(a, b) {
+		return b
+	}
+	return a
+}
+
+// over reports whether the wei amount a is more than the limit.
+func over(a, limit string) bool {
+	if limit == "" {
+		return false
+	}
+	x, err := util.NewUint128FromString(a)
+	if err != nil {
+		return true
+	}
+	y, err := util.NewUint128FromString(limit)
+	if err != nil {
+		return true
+	}
+	return x.Cmp(y) > 0
+}
+
+// raises reports whether going from the limit a to b raises it.
+func raises(a, b string) bool {
+	return a != "" && (b == "" || over(b, a))
+}
+
+// current returns the limits in effect at now.
+func (o ownLimits) current(now time.Time) limits {
+	if o.Raised != nil && !now.Before(o.RaisedFrom) {
+		return *o.Raised
+	}
+	return o.limits
+}
+
+// userLimits returns the limits of userID: the bot's, or their own where they're lower.
+func userLimits(userID string) (limits, error) {
+	var o ownLimits
+	_, err := state.get(limitsBucket, userID, &o)
+	if err != nil {
+		return limits{}, err
+	}
+	own := o.current(time.Now())
+	return limits{
+		lowerLimit(botLimits.PerTx, own.PerTx),
+		lowerLimit(botLimits.Daily, own.Daily),
+		lowerLimit(botLimits.Code, own.Code),
+	}, nil
+}
+
+// checkTx refuses an amount over the limit per transaction.
+func (l limits) checkTx(amount *util.Uint128) error {
+	if over(amount.String(), l.PerTx) {
+		return fmt.Errorf("%v NAS is over your limit of %v NAS per transaction", formatNAS(amount), amountNAS(l.PerTx))
+	}
+	return nil
+}
+
+// spendLocks hold a mutex per user, see lockSpend.
+var spendLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: map[string]*sync.Mutex{}}
+
+// lockSpend locks the sends of userID and returns the function that unlocks them. It's held
+// from checkSpend until the ledger records the transaction, so that two sends at once can't
+// both fit under the daily limit.
+func lockSpend(userID string) func() {
+	spendLocks.Lock()
+	mu, ok := spendLocks.m[userID]
+	if !ok {
+		mu = &sync.Mutex{}
+		spendLocks.m[userID] = mu
+	}
+	spendLocks.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkSpend refuses amount from userID if it's over their limit per transaction, or if it
+// takes what they sent in the last 24 hours over their daily limit. Every transfer from a
+// user's account goes through it before it's signed, under lockSpend.
+func checkSpend(userID string, amount *util.Uint128) error {
+	l, err := userLimits(userID)
+	if err != nil {
+		return err
+	}
+	err = l.checkTx(amount)
+	if err != nil || l.Daily == "" {
+		return err
+	}
+
+	sent, err := sentSince(userID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	total, err := sent.Add(amount)
+	if err != nil || over(total.String(), l.Daily) {
+		return fmt.Errorf("%v NAS is over your daily limit of %v NAS, you've sent %v NAS in the last 24 hours", formatNAS(amount), amountNAS(l.Daily), formatNAS(sent))
+	}
+	return nil
+}
+
+// sentSince adds up what userID sent from their account since the given time, as recorded
+// in the ledger. Transactions that failed don't count.
+func sentSince(userID string, since time.Time) (*util.Uint128, error) {
+	const pageSize = 50
+	sent := util.NewUint128()
+	for offset := 0; ; offset += pageSize {
+		entries, _, err := state.ledger(userID, offset, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.Created.Before(since) {
+				return sent, nil
+			}
+			if e.SenderID != userID || e.Status == txFailed {
+				continue
+			}
+			if e.Kind != ledgerTip && e.Kind != ledgerTransfer && e.Kind != ledgerEscrow {
+				continue
+			}
+			amount, err := util.NewUint128FromString(e.Amount)
+			if err != nil {
+				return nil, err
+			}
+			sent, err = sent.Add(amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(entries) < pageSize {
+			return sent, nil
+		}
+	}
+}
+
+// refuseSpend DMs userID why amount can't be sent, and reports whether it can't.
+func refuseSpend(p Platform, userID string, amount *util.Uint128) bool {
+	err := checkSpend(userID, amount)
+	if err != nil {
+		dm(p, userID, fmt.Sprintf("Sorry, %v.", err))
+		return true
+	}
+	return false
+}
+
+// needsCode reports whether the amount of c is large enough that its sender has to type
+// back a one-time code to confirm it.
+func needsCode(c confirmation) bool {
+	l, err := userLimits(c.Waiter.SenderID)
+	if err != nil {
+		fmt.Println(err)
+		// Ask for the code rather than let a large amount through.
+		return true
+	}
+	return over(c.Waiter.Amount, l.Code)
+}
+
+func newCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n), nil
+}
+
+// isCode reports whether text looks like a one-time code.
+func isCode(text string) bool {
+	if len(text) != codeDigits {
+		return false
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// askCode stores c with a one-time code and asks its sender to type it back, in place
+// of the yes they already answered. The code has its own deadline, set once: answering
+// yes again only repeats the question.
+func askCode(p Platform, c confirmation) {
+	if c.Code != "" {
+		err := state.putConfirmation(c)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		dm(p, c.Waiter.SenderID, fmt.Sprintf(`To send %v NAS, type the code I sent you, or "no" to cancel.`, amountNAS(c.Waiter.Amount)))
+		return
+	}
+
+	code, err := newCode()
+	if err != nil {
+		fmt.Println(err)
+		dm(p, c.Waiter.SenderID, "Sorry, something went wrong. Transaction not sent.")
+		return
+	}
+	c.Code = code
+	c.Deadline = time.Now().Add(confirmTimeout)
+
+	err = state.putConfirmation(c)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	dm(p, c.Waiter.SenderID, fmt.Sprintf(`That's a large amount. To send %v NAS, type this code within %v: %v
+Type "no" to cancel.`, amountNAS(c.Waiter.Amount), formatPeriod(confirmTimeout), c.Code))
+	confirmTxTimeout(p, c)
+}
+
+// confirmCodeResponse starts the confirmation of senderID that waits for code, and reports
+// whether there was one. A wrong code cancels it.
+func confirmCodeResponse(p Platform, senderID string, code string) bool {
+	c, ok, err := state.takeConfirmation(senderID, "")
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !ok {
+		return false
+	}
+	if c.Code == "" {
+		// It waits for a yes, leave it be.
+		err = state.putConfirmation(c)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(code), []byte(c.Code)) != 1 {
+		dm(p, senderID, "Wrong code. Transaction not sent.")
+		return true
+	}
+	startConfirmed(p, c)
+	return true
+}
+
+// limitsCmd DMs userID the limits they have and what they sent in the last 24 hours.
+func limitsCmd(p Platform, userID string) {
+	var o ownLimits
+	_, err := state.get(limitsBucket, userID, &o)
+	var l limits
+	if err == nil {
+		l, err = userLimits(userID)
+	}
+	var sent *util.Uint128
+	if err == nil {
+		sent, err = sentSince(userID, time.Now().Add(-24*time.Hour))
+	}
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+
+	text := "Your limits: "
+	if l.PerTx == "" {
+		text += "no limit per transaction, "
+	} else {
+		text += fmt.Sprintf("%v NAS per transaction, ", amountNAS(l.PerTx))
+	}
+	if l.Daily == "" {
+		text += "no daily limit. "
+	} else {
+		text += fmt.Sprintf("%v NAS a day. ", amountNAS(l.Daily))
+	}
+	if l.Code != "" {
+		text += fmt.Sprintf("Amounts over %v NAS need a one-time code. ", amountNAS(l.Code))
+	}
+	text += fmt.Sprintf("You've sent %v NAS in the last 24 hours.", formatNAS(sent))
+	if o.Raised != nil && time.Now().Before(o.RaisedFrom) {
+		text += fmt.Sprintf("\nThe limits you raised take effect on %v.", formatTime(o.RaisedFrom))
+	}
+	dm(p, userID, text+"\n"+`Lower them like "limits daily 10 NAS", or type "limits reset" to go back to the defaults.`)
+}
+
+// setLimitCmd sets the limit name of userID to amount, which can't be above the bot's own.
+func setLimitCmd(p Platform, userID string, name string, amount *util.Uint128) {
+	if max := *limitNames[name](&botLimits); over(amount.String(), max) {
+		dm(p, userID, fmt.Sprintf("Sorry, that limit can be at most %v NAS.", amountNAS(max)))
+		return
+	}
+	changeLimits(p, userID, func(l *limits) {
+		*limitNames[name](l) = amount.String()
+	})
+}
+
+// resetLimitsCmd drops the limits userID set, leaving the bot's.
+func resetLimitsCmd(p Platform, userID string) {
+	changeLimits(p, userID, func(l *limits) {
+		*l = limits{}
+	})
+}
+
+// changeLimits applies change to the limits userID set. Lower limits take effect at once,
+// higher ones after limitRaiseDelay, and a change in between starts from the current ones.
+func changeLimits(p Platform, userID string, change func(l *limits)) {
+	var o ownLimits
+	_, err := state.get(limitsBucket, userID, &o)
+	if err == nil {
+		now := time.Now()
+		cur := o.current(now)
+		l := cur
+		change(&l)
+
+		o = ownLimits{limits: l}
+		for _, field := range limitNames {
+			if raises(*field(&cur), *field(&l)) {
+				o = ownLimits{limits: cur, Raised: &l, RaisedFrom: now.Add(limitRaiseDelay)}
+				break
+			}
+		}
+		err = state.put(limitsBucket, userID, o)
+	}
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+	limitsCmd(p, userID)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -10,18 +11,84 @@ import (
 	// _ "github.com/joho/godotenv/autoload"
 )
 
-var contractAddress = "n1euKcZAkpvAhLegcryk5qFFuV3v7GzFHNG"
-var botPriv, _ = hex.DecodeString(os.Getenv("bot"))
-var bot, _ = newAccount(botPriv)
+var bot, errorBotKey = loadBot(os.Getenv)
+
+// loadBot reads the bot's own key from the hex "bot" env var. It pays for contract writes and
+// holds escrowed tips, so a missing key mustn't quietly become a new one.
+func loadBot(getenv func(string) string) (account, error) {
+	priv, err := hex.DecodeString(getenv("bot"))
+	if err != nil || len(priv) != 32 {
+		return account{}, errors.New("bot must be set to the hex private key of the bot's account")
+	}
+	return newAccount(priv)
+}
+
+// configError returns the first error in the config the bot needs to send transactions:
+// its key, the limits and the key store.
+func configError() error {
+	for _, err := range []error{errorBotKey, errorLimits, errorKeyStore} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateFile is the path of the bot's state, set with the "stateFile" env var.
+func stateFile() string {
+	path := os.Getenv("stateFile")
+	if path == "" {
+		path = "neby.db"
+	}
+	return path
+}
 
 func uint128(i uint64) *util.Uint128 {
 	return util.NewUint128FromUint(i)
 }
 
 func main() {
+	if errorNetwork != nil {
+		fmt.Println(errorNetwork)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	if err := configError(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ps, err := loadPlatforms(os.Getenv)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	state, err = openState(stateFile())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = resume(ps)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	defer persist()
-	fmt.Println("Nastwitter v1")
-	go stream()
+	fmt.Printf("Nastwitter v1 (%v, chain %d)\n", active.name, active.chainID)
+	for _, p := range ps {
+		go serve(p)
+	}
+	go deposits.run(ps)
+	go escrows.run(ps)
+	go giveaways.run(ps)
+	go schedules.run(ps)
 }
 
 func persist() {
@@ -1,17 +1,37 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ChimeraCoder/anaconda"
+	"github.com/gorilla/websocket"
+	bolt "go.etcd.io/bbolt"
 
 	"./nebulas"
+	"./nebulas/crypto/cipher"
+	"./nebulas/crypto/keystore/secp256k1"
+	"./nebulas/util"
 )
 
 var acc, _ = newAccount(nil)
-var tweet = anaconda.Tweet{Text: "@NebBot send 5 NAS, thanks"}
+var tweet = mention{Text: "@NebBot send 5 NAS, thanks"}
 var tx, _ = newTx(txParams{
 	acc.addr,
 	acc.addr,
@@ -23,26 +43,175 @@ var tx, _ = newTx(txParams{
 	nil,
 })
 
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "neby")
+	if err != nil {
+		panic(err)
+	}
+	state, err = openState(filepath.Join(dir, "state.db"))
+	if err != nil {
+		panic(err)
+	}
+	// Tests run without a bot key, the test account stands in for it.
+	if errorBotKey != nil {
+		bot = acc
+	}
+
+	code := m.Run()
+	state.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestParseStatus(t *testing.T) {
-	amount, err := parseStatus(tweet)
+	cmd, err := parseStatus(tweet)
 	if err != nil {
 		t.Error(err)
-	} else if amount != 5 {
-		t.Errorf("Amount was incorrect, got: %v, want: %v.\n", amount, 5)
+	} else if cmd.Amount.String() != "5000000000000000000" || cmd.Recipient != "" || cmd.Memo != "thanks" {
+		t.Errorf("Tip was incorrect, got: %+v, want: %v.\n", cmd, "5 NAS with memo thanks")
 	}
 
-	amount, err = parseStatus(anaconda.Tweet{Text: "This shouldn't work"})
-	if err == nil {
-		t.Errorf("Invalid argument didn't throw an error: %v\n", amount)
-	} else if amount != 0 {
-		t.Errorf("Amount was incorrect, got: %v, want: %v.\n", amount, 0)
+	for _, c := range []struct {
+		text, amount, recipient, memo string
+	}{
+		{"@NebBot send 0.1 NAS", "100000000000000000", "", ""},
+		{"@NebBot send 0.1 NAS to @bob", "100000000000000000", "bob", ""},
+		{"@NebBot give 500 mNAS to @bob", "500000000000000000", "bob", ""},
+		{"@NebBot drop 1e-3nas on @bob!", "1000000000000000", "bob", ""},
+		{"@NebBot send 25 NAS, @bob", "25000000000000000000", "bob", ""},
+		{"@NebBot send 0.123456789012345678 NAS to @bob", "123456789012345678", "bob", ""},
+		{"  @nebbot   SEND\t2\n nas   to  @bob  for the  coffee ", "2000000000000000000", "bob", "for the  coffee"},
+		{"@alice @NebBot donate 3 NAS", "3000000000000000000", "", ""},
+		{"@NebBot send 5 @bob thanks!", "5000000000000000000", "bob", "thanks!"},
+		{"@NebBot send 5 to @bob:example.org.", "5000000000000000000", "bob:example.org", ""},
+		{"@NebBot send 5", "5000000000000000000", "", ""},
+		{"@NebBot wire 7 wei good job", "7", "", "good job"},
+	} {
+		cmd, err := parseStatus(mention{Text: c.text})
+		if err != nil || cmd.Amount.String() != c.amount || cmd.Recipient != c.recipient || cmd.Memo != c.memo {
+			t.Errorf("Tip %q was incorrect, got: %+v, %v, want: %v, %q, %q.\n", c.text, cmd, err, c.amount, c.recipient, c.memo)
+		}
 	}
 
-	amount, err = parseStatus(anaconda.Tweet{Text: "@NebBot send five NAS"})
-	if err == nil {
-		t.Errorf("Invalid argument didn't throw an error: %v\n", amount)
-	} else if amount != 0 {
-		t.Errorf("Amount was incorrect, got: %v, want: %v.\n", amount, 0)
+	for text, want := range map[string]string{
+		"This shouldn't work":           errorNotCommand.Error(),
+		"@NebBot hello there":           errorNotCommand.Error(),
+		"@NebBot send":                  "expected an amount",
+		"@NebBot send five NAS":         `expected an amount, got "five"`,
+		"@NebBot send 5 NASA to @bob":   `expected a unit (NAS, mNAS, µNAS or wei) or an @handle, got "NASA"`,
+		"@NebBot send 5 BTC":            `expected a unit (NAS, mNAS, µNAS or wei) or an @handle, got "BTC"`,
+		"@NebBot send 5BTC":             `unknown unit, use NAS, mNAS, µNAS or wei, got "5BTC"`,
+		"@NebBot send 0 NAS to @bob":    `amount must be more than 0, got "0"`,
+		"@NebBot send 5 NAS to bob":     `expected an @handle, got "bob"`,
+		"@NebBot send 5 NAS to":         "expected an @handle",
+		"@NebBot send 1e40 NAS to @bob": `amount is too large, got "1e40"`,
+	} {
+		cmd, err := parseStatus(mention{Text: text})
+		if err == nil {
+			t.Errorf("Invalid argument %q didn't throw an error: %+v\n", text, cmd)
+		} else if err.Error() != want {
+			t.Errorf("Error of %q was incorrect, got: %v, want: %v.\n", text, err, want)
+		}
+	}
+}
+
+func TestParseChatCmd(t *testing.T) {
+	addr := acc.addr.String()
+	for text, want := range map[string]chatCmd{
+		"help":                               {Name: "help"},
+		" Balance ":                          {Name: "balance"},
+		"transfer":                           {Name: "transfer"},
+		"transfer " + addr + " 1.5":          {Name: "transfer", To: acc.addr, Amount: uint128(1500000000000000000)},
+		"TRANSFER  " + addr + "  2 NAS rent": {Name: "transfer", To: acc.addr, Amount: uint128(2000000000000000000), Memo: "rent"},
+		"history":                            {Name: "history", Page: 1},
+		"history 3":                          {Name: "history", Page: 3},
+		"export":                             {Name: "export"},
+		"export History":                     {Name: "export history"},
+		"export  My Secret  Phrase ":         {Name: "export"},
+		"schedule":                           {Name: "schedule"},
+		"schedules":                          {Name: "schedule list"},
+		"schedule list":                      {Name: "schedule list"},
+		"schedule cancel #3":                 {Name: "schedule cancel", ScheduleID: 3},
+		"schedule resume 12":                 {Name: "schedule resume", ScheduleID: 12},
+		"schedule 1 NAS to @bob every week":  {Name: "schedule", Amount: uint128(1000000000000000000), Recipient: "bob", When: scheduleSpec{Every: 7 * 24 * time.Hour}},
+		"schedule 2 to " + addr + " every 36h rent":                 {Name: "schedule", To: acc.addr, Amount: uint128(2000000000000000000), Memo: "rent", When: scheduleSpec{Every: 36 * time.Hour}},
+		"schedule 1 NAS to @bob cron 0 9 * * 1":                     {Name: "schedule", Amount: uint128(1000000000000000000), Recipient: "bob", When: scheduleSpec{Cron: "0 9 * * 1"}},
+		"schedule 1 NAS to @bob at 2999-01-31 12:30 happy birthday": {Name: "schedule", Amount: uint128(1000000000000000000), Recipient: "bob", Memo: "happy birthday", When: scheduleSpec{At: time.Date(2999, 1, 31, 12, 30, 0, 0, time.UTC)}},
+		"limits":               {Name: "limits"},
+		"limit reset":          {Name: "limits reset"},
+		"limits daily 10 NAS":  {Name: "limits set", Limit: "daily", Amount: uint128(10000000000000000000)},
+		"limits transaction 2": {Name: "limits set", Limit: "tx", Amount: uint128(2000000000000000000)},
+	} {
+		got, err := parseChatCmd(text)
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Command %q was incorrect, got: %+v, %v, want: %+v.\n", text, got, err, want)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"":                                        errorUnknownCommand.Error(),
+		"thanks!":                                 errorUnknownCommand.Error(),
+		"transfer n1nope 5":                       `expected a NAS address, got "n1nope"`,
+		"transfer " + addr:                        "expected an amount",
+		"transfer " + addr + " 5 rent":            `expected a unit (NAS, mNAS, µNAS or wei), got "rent"`,
+		"history last":                            `expected a page number, got "last"`,
+		"history 0":                               `expected a page number, got "0"`,
+		"schedule cancel":                         "expected a schedule number",
+		"schedule cancel all":                     `expected a schedule number, got "all"`,
+		"schedule 1 NAS @bob":                     `expected "to", got "@bob"`,
+		"schedule 1 to bob every week":            `expected an @handle or a NAS address, got "bob"`,
+		"schedule 1 to @bob":                      "expected every, in, at or cron",
+		"schedule 1 to @bob weekly":               `expected every, in, at or cron, got "weekly"`,
+		"schedule 1 to @bob every 5m":             `tips can repeat at most every 1 hour, got "5m"`,
+		"schedule 1 to @bob every now":            `expected a period like "week", "3 days" or "12h", got "now"`,
+		"schedule 1 to @bob at 2001-01-01":        `expected a time in the future, got "2001-01-01"`,
+		"schedule 1 to @bob cron 0 9 * *":         "expected 5 cron fields: minute hour day month weekday",
+		"schedule 1 to @bob cron 0 25 * * *":      `expected a cron field, got "25"`,
+		"schedule 1 to @bob cron * * * * *":       `tips can repeat at most every 1 hour, got "* * * * *"`,
+		"schedule 1 to @bob cron 0,30 8,20 * * *": `tips can repeat at most every 1 hour, got "0,30 8,20 * * *"`,
+		"schedule 1 to @bob cron 0,30 9 31 * *":   `tips can repeat at most every 1 hour, got "0,30 9 31 * *"`,
+		"limits weekly 5":                         `expected tx, daily, code or reset, got "weekly"`,
+		"limits code":                             "expected an amount",
+		"limits tx 5 NAS please":                  `expected nothing after the amount, got "please"`,
+		"limits reset all":                        `expected nothing after reset, got "all"`,
+	} {
+		if _, err := parseChatCmd(text); err == nil || err.Error() != want {
+			t.Errorf("Error of %q was incorrect, got: %v, want: %v.\n", text, err, want)
+		}
+	}
+}
+
+func TestCron(t *testing.T) {
+	from := time.Date(2026, 10, 17, 16, 20, 30, 0, time.UTC) // A Saturday.
+	for line, want := range map[string]string{
+		"0 9 * * 1":          "2026-10-19 09:00",
+		"*/15 * * * *":       "2026-10-17 16:30",
+		"10 16 * * *":        "2026-10-18 16:10",
+		"0 0 1 1 *":          "2027-01-01 00:00",
+		"0 12 31 * *":        "2026-10-31 12:00",
+		"0 12 1,15 * 0":      "2026-10-18 12:00",
+		"0 8-10/2 * * 7":     "2026-10-18 08:00",
+		"0 0 29 2 *":         "2028-02-29 00:00",
+		"45 23 * 12 mon-fri": "",
+	} {
+		c, err := parseCron(line)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Invalid cron line %q was accepted.\n", line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cron line %q wasn't accepted: %v.\n", line, err)
+			continue
+		}
+		if got := c.next(from).Format("2006-01-02 15:04"); got != want {
+			t.Errorf("Next run of %q was incorrect, got: %v, want: %v.\n", line, got, want)
+		}
+	}
+
+	if c, _ := parseCron("0 0 31 2 *"); !c.next(from).IsZero() {
+		t.Error("Cron line that never matches has a next run.")
 	}
 }
 
@@ -73,8 +242,7 @@ func TestDecrypt(t *testing.T) {
 }
 
 func TestGetAcc(t *testing.T) {
-	var nonce uint64
-	_, err := getAcc(123456, 123456, &nonce)
+	_, err := getAcc("123456")
 	if err != nil && err != errorNotInStorage {
 		t.Error(err)
 	}
@@ -134,3 +302,2053 @@ func TestReaction(t *testing.T) {
 		reaction()
 	}
 }
+
+func TestRPCClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/user/accountstate":
+			w.Write([]byte(`{"result":{"balance":"1000000000000000000","nonce":"7","type":87}}`))
+		case "/v1/user/rawtransaction":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"transaction's nonce is invalid, should bigger than the from's nonce"}`))
+		case "/v1/user/getGasPrice":
+			w.Write([]byte(`{"result":{"gas_price":"1000000"}}`))
+		default:
+			w.Write([]byte(`{"result":{"nonce":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := newRPCClient(srv.URL)
+
+	state, err := c.accountState(acc.addr)
+	if err != nil {
+		t.Error(err)
+	} else if state.Nonce != 7 || state.Balance.String() != "1000000000000000000" {
+		t.Errorf("Account state was incorrect, got: %+v.\n", state)
+	}
+
+	_, err = c.sendRawTransaction("")
+	if e, ok := err.(*rpcError); !ok || e.StatusCode != 400 {
+		t.Errorf("Expected an rpcError, got: %v.\n", err)
+	}
+
+	price, err := c.gasPrice()
+	if err != nil {
+		t.Error(err)
+	} else if price.Uint64() != 1000000 {
+		t.Errorf("Gas price was incorrect, got: %v, want: %v.\n", price, 1000000)
+	}
+
+	_, err = c.transactionReceipt("abc")
+	if err != errorDecodeJSON {
+		t.Errorf("Malformed response didn't return errorDecodeJSON: %v\n", err)
+	}
+}
+
+func TestLoadNetwork(t *testing.T) {
+	env := map[string]string{"network": "testnet", "contractAddress": "n1euKcZAkpvAhLegcryk5qFFuV3v7GzFHNG"}
+	n, err := loadNetwork(func(k string) string { return env[k] })
+	if err != nil {
+		t.Error(err)
+	} else if n.chainID != 1001 || n.url != "https://testnet.nebulas.io" {
+		t.Errorf("Testnet profile was incorrect, got: %+v.\n", n)
+	}
+
+	env = map[string]string{"network": "local", "chainID": "abc", "contractAddress": "n1euKcZAkpvAhLegcryk5qFFuV3v7GzFHNG"}
+	_, err = loadNetwork(func(k string) string { return env[k] })
+	if err == nil {
+		t.Error("Invalid chainID didn't throw an error.")
+	}
+
+	env = map[string]string{"network": "testnet"}
+	_, err = loadNetwork(func(k string) string { return env[k] })
+	if err == nil {
+		t.Error("Missing contract address didn't throw an error.")
+	}
+
+	n, err = loadNetwork(func(string) string { return "" })
+	if err != nil {
+		t.Error(err)
+	} else if n.chainID != 1 {
+		t.Errorf("Default chain was incorrect, got: %v, want: %v.\n", n.chainID, 1)
+	}
+}
+
+func TestTxTracker(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch polls {
+		case 1:
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"transaction not found"}`))
+		case 2:
+			w.Write([]byte(`{"result":{"hash":"abc","status":2}}`))
+		default:
+			w.Write([]byte(`{"result":{"hash":"abc","status":0,"execute_error":"insufficient balance"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	tr := &txTracker{newRPCClient(srv.URL), time.Millisecond, time.Second}
+	r, err := tr.wait("abc")
+	if err != errorTxFailed {
+		t.Errorf("Failed tx wasn't reported, got: %v.\n", err)
+	} else if r.ExecuteError != "insufficient balance" || polls != 3 {
+		t.Errorf("Receipt was incorrect, got: %+v after %v polls.\n", r, polls)
+	}
+
+	tr = &txTracker{newRPCClient(srv.URL), time.Millisecond, 0}
+	polls = 1
+	_, err = tr.wait("abc")
+	if err != errorTxTimeout {
+		t.Errorf("Pending tx didn't time out, got: %v.\n", err)
+	}
+}
+
+func TestNonceManager(t *testing.T) {
+	var mu sync.Mutex
+	nonce := 7
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"result":{"balance":"0","nonce":"%d","type":87}}`, nonce)
+	}))
+	defer srv.Close()
+
+	m := newNonceManager(newRPCClient(srv.URL))
+	seen := sync.Map{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := m.next(acc.addr)
+			if err != nil {
+				t.Error(err)
+			} else if _, dup := seen.LoadOrStore(n, true); dup {
+				t.Errorf("Nonce %v was handed out twice.\n", n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.release(acc.addr, 17)
+	if n, _ := m.peek(acc.addr); n != 17 {
+		t.Errorf("Peeked nonce was incorrect, got: %v, want: %v.\n", n, 17)
+	}
+	if n, _ := m.next(acc.addr); n != 17 {
+		t.Errorf("Released nonce wasn't reused, got: %v, want: %v.\n", n, 17)
+	}
+
+	m.release(acc.addr, 12)
+	m.release(acc.addr, 14)
+	m.resync(acc.addr)
+	for _, want := range []uint64{12, 14, 18} {
+		if n, _ := m.next(acc.addr); n != want {
+			t.Errorf("Nonces after a resync were incorrect, got: %v, want: %v.\n", n, want)
+		}
+	}
+
+	// The node is ahead once other transactions were mined.
+	mu.Lock()
+	nonce = 20
+	mu.Unlock()
+	m.release(acc.addr, 15)
+	m.resync(acc.addr)
+	if n, _ := m.next(acc.addr); n != 21 {
+		t.Errorf("Resync didn't catch up with the node, got: %v, want: %v.\n", n, 21)
+	}
+
+	if !isNonceError(&rpcError{"/v1/user/rawtransaction", 400, "transaction's nonce is invalid, should bigger than the from's nonce"}) {
+		t.Error("Nonce rejection wasn't recognised.")
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/user/getGasPrice":
+			w.Write([]byte(`{"result":{"gas_price":"2000000000000"}}`))
+		case "/v1/user/estimateGas":
+			w.Write([]byte(`{"result":{"gas":"20000","err":""}}`))
+		case "/v1/user/accountstate":
+			w.Write([]byte(`{"result":{"balance":"1000000000000000","nonce":"0","type":87}}`))
+		}
+	}))
+	defer srv.Close()
+
+	defer func(n *rpcClient) { node = n }(node)
+	node = newRPCClient(srv.URL)
+
+	price, limit, err := estimateFee(acc.addr, acc.addr, uint128(0), 1, core.TxPayloadBinaryType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price.Cmp(core.TransactionMaxGasPrice) != 0 {
+		t.Errorf("Gas price wasn't clamped, got: %v, want: %v.\n", price, core.TransactionMaxGasPrice)
+	}
+	if limit.Uint64() != 24000 {
+		t.Errorf("Gas limit was incorrect, got: %v, want: %v.\n", limit, 24000)
+	}
+
+	err = checkBalance(acc.addr, uint128(0), uint128(1000000), limit)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = checkBalance(acc.addr, uint128(1000000000000000), uint128(1000000), limit)
+	if err == nil {
+		t.Error("Transfer over the balance didn't throw an error.")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	os.Setenv("secret", "123456789abcdefg")
+
+	enc, err := encrypt(acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if isLegacyEnvelope(enc) {
+		t.Error("New envelope was detected as legacy.")
+	}
+
+	again, _ := encrypt(acc)
+	if again == enc {
+		t.Error("Envelopes of the same key should differ.")
+	}
+
+	key, err := decrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := getPrivateKeyByteArray(acc)
+	if hex.EncodeToString(key) != hex.EncodeToString(want) {
+		t.Error("Decrypted key doesn't match.")
+	}
+
+	tampered := []byte(enc)
+	tampered[len(tampered)-1] ^= 1
+	if _, err = decrypt(string(tampered)); err == nil {
+		t.Error("Tampered envelope was accepted.")
+	}
+
+	if _, err = decrypt("02" + enc[2:]); err != errorUnknownVersion {
+		t.Errorf("Unknown version wasn't rejected, got: %v.\n", err)
+	}
+
+	legacy := `"486b2395e856db1981bb611739193fee7e1e19f2402192bb83d3375b40655492"`
+	if !isLegacyEnvelope(legacy) {
+		t.Error("Legacy envelope wasn't detected.")
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	os.Setenv("secret", "123456789abcdefg")
+
+	dir, err := ioutil.TempDir("", "neby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+	file, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []KeyStore{newMemoryStore(), file} {
+		if _, err := store.Get("123456"); err != errorNotInStorage {
+			t.Errorf("Missing key didn't return errorNotInStorage: %v\n", err)
+		}
+
+		err = store.Put("123456", acc)
+		if err != nil {
+			t.Error(err)
+		}
+
+		got, err := store.Get("123456")
+		if err != nil {
+			t.Error(err)
+		} else if !got.addr.Equals(acc.addr) {
+			t.Errorf("Stored account was incorrect, got: %v, want: %v.\n", got.addr, acc.addr)
+		}
+
+		err = store.Rotate("123456")
+		if err != nil {
+			t.Error(err)
+		}
+
+		ids, _ := store.List()
+		if len(ids) != 1 || ids[0] != "123456" {
+			t.Errorf("Listed IDs were incorrect, got: %v.\n", ids)
+		}
+	}
+
+	reopened, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Get("123456"); err != nil {
+		t.Errorf("Key file wasn't persisted: %v\n", err)
+	}
+}
+
+func TestContractStoreList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"result":"","execute_err":"TypeError: this.listAccounts is not a function"}}`))
+	}))
+	defer srv.Close()
+	defer func(n *rpcClient) { node = n }(node)
+	node = newRPCClient(srv.URL)
+
+	if _, err := (&contractStore{}).List(); err != errorNoAccountList {
+		t.Errorf("Contract without listAccounts wasn't explained, got: %v.\n", err)
+	}
+
+	// The users in the state are migrated instead.
+	id := fmt.Sprint("fake:listed-", time.Now().UnixNano())
+	state.watchAddress(acc.addr.String(), watchedUser{"fake", id})
+	ids, err := state.userIDs()
+	found := false
+	for _, u := range ids {
+		found = found || u == id
+	}
+	if err != nil || !found {
+		t.Errorf("User with a watched address wasn't listed, got: %v, %v.\n", ids, err)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	defer func(s *cipher.Scrypt) { keyFileScrypt = s }(keyFileScrypt)
+	keyFileScrypt = &cipher.Scrypt{N: 1 << 10}
+
+	kf, err := exportKeyFile(acc, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := importKeyFile(kf, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := priv.Encoded()
+	want, _ := getPrivateKeyByteArray(acc)
+	if hex.EncodeToString(got) != hex.EncodeToString(want) {
+		t.Error("Imported key doesn't match.")
+	}
+
+	_, err = importKeyFile(kf, "wrong horse")
+	if err != cipher.ErrDecrypt {
+		t.Errorf("Wrong passphrase wasn't rejected, got: %v.\n", err)
+	}
+
+	other, _ := newAccount(nil)
+	var forged map[string]interface{}
+	json.Unmarshal(kf, &forged)
+	forged["address"] = other.addr.String()
+	data, _ := json.Marshal(forged)
+	if _, err = importKeyFile(data, "correct horse"); err != errorKeyFileAddress {
+		t.Errorf("Key file of another address was accepted, got: %v.\n", err)
+	}
+
+	// The scrypt test vector of the Web3 Secret Storage spec, with the hex address geth writes.
+	data, err = ioutil.ReadFile("testdata/keyfile/ethereum.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err = importKeyFile(data, "testpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := priv.Encoded(); hex.EncodeToString(got) != "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d" {
+		t.Errorf("Key imported from an Ethereum key file was incorrect, got: %x.\n", got)
+	}
+}
+
+func TestHDStore(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	s, err := newHDStore(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.Get("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := newHDStore(mnemonic, "")
+	b, _ := again.Get("123456")
+	if !a.addr.Equals(b.addr) {
+		t.Error("The same mnemonic derived different accounts.")
+	}
+
+	c, _ := s.Get("123457")
+	if a.addr.Equals(c.addr) {
+		t.Error("Different users derived the same account.")
+	}
+
+	for _, i := range userPath("123456") {
+		if i < secp256k1.HardenedKeyStart {
+			t.Errorf("User path index wasn't hardened, got: %v.\n", i)
+		}
+	}
+
+	if err = s.Put("123456", a); err != nil {
+		t.Error(err)
+	}
+	if err = s.Put("123456", acc); err != errorHDNotStored {
+		t.Errorf("Foreign account was accepted, got: %v.\n", err)
+	}
+
+	if _, err = newHDStore("abandon abandon", ""); err != errorInvalidMnemonic {
+		t.Errorf("Invalid mnemonic wasn't rejected, got: %v.\n", err)
+	}
+}
+
+// fakePlatform replays events and records what the bot sends back.
+type fakePlatform struct {
+	mu      sync.Mutex
+	events  []interface{}
+	dms     map[string][]string
+	replies map[string][]string
+}
+
+func newFakePlatform(events ...interface{}) *fakePlatform {
+	return &fakePlatform{events: events, dms: map[string][]string{}, replies: map[string][]string{}}
+}
+
+func (f *fakePlatform) Name() string {
+	return "fake"
+}
+
+func (f *fakePlatform) Listen(events chan<- interface{}) error {
+	for _, e := range f.events {
+		events <- e
+		// Let the goroutines serve starts for e run before the next event, like a human would.
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (f *fakePlatform) SendDM(userID string, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dms[userID] = append(f.dms[userID], text)
+	return nil
+}
+
+func (f *fakePlatform) Reply(statusID string, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[statusID] = append(f.replies[statusID], text)
+	return nil
+}
+
+func (f *fakePlatform) LookupUser(name string) (user, error) {
+	return user{"fake:" + name, name}, nil
+}
+
+func (f *fakePlatform) sent(userID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.dms[userID]...)
+}
+
+// waitSent waits until userID got n DMs, for at most a second, and returns them.
+func (f *fakePlatform) waitSent(userID string, n int) []string {
+	return f.wait(f.dms, userID, n)
+}
+
+// waitReplies waits until statusID got n replies, for at most a second, and returns them.
+func (f *fakePlatform) waitReplies(statusID string, n int) []string {
+	return f.wait(f.replies, statusID, n)
+}
+
+func (f *fakePlatform) wait(texts map[string][]string, key string, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.mu.Lock()
+		got := append([]string(nil), texts[key]...)
+		f.mu.Unlock()
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeNode is a node that accepts every transaction but the ones in refuse, numbered from 1,
+// and hashes them prefix-1, prefix-2 and so on. They all succeed, unless status holds
+// another receipt status for their hash. newFakeNode points node, nonces, tracker and
+// keys at it, and close puts them back.
+type fakeNode struct {
+	srv     *httptest.Server
+	restore func()
+
+	mu      sync.Mutex
+	prefix  string
+	balance string
+	height  int
+	sent    int
+	refuse  map[int]bool
+	status  map[string]int
+}
+
+func newFakeNode(prefix string) *fakeNode {
+	f := &fakeNode{prefix: prefix, balance: "100000000000000000000", height: 100, refuse: map[int]bool{}, status: map[string]int{}}
+	f.srv = httptest.NewServer(f)
+
+	n, nm, tr, k := node, nonces, tracker, keys
+	f.restore = func() { node, nonces, tracker, keys = n, nm, tr, k }
+	node = newRPCClient(f.srv.URL)
+	nonces = newNonceManager(node)
+	tracker = &txTracker{node, time.Millisecond, time.Second}
+	keys = newMemoryStore()
+	return f
+}
+
+func (f *fakeNode) close() {
+	f.restore()
+	f.srv.Close()
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/v1/user/nebstate":
+		fmt.Fprintf(w, `{"result":{"chain_id":100,"height":"%d"}}`, f.height)
+	case "/v1/user/getBlockByHeight":
+		fmt.Fprintf(w, `{"result":{"hash":"c0ffee","height":"%d","timestamp":"1700000000","transactions":[]}}`, f.height)
+	case "/v1/user/getGasPrice":
+		w.Write([]byte(`{"result":{"gas_price":"1000000"}}`))
+	case "/v1/user/estimateGas":
+		w.Write([]byte(`{"result":{"gas":"20000","err":""}}`))
+	case "/v1/user/accountstate":
+		fmt.Fprintf(w, `{"result":{"balance":%q,"nonce":"0","type":87}}`, f.balance)
+	case "/v1/user/rawtransaction":
+		f.sent++
+		if f.refuse[f.sent] {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid transaction"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"result":{"txhash":"%v-%d"}}`, f.prefix, f.sent)
+	case "/v1/user/getTransactionReceipt":
+		var req struct{ Hash string }
+		json.NewDecoder(r.Body).Decode(&req)
+		status, ok := f.status[req.Hash]
+		if !ok {
+			status = core.TxExecutionSuccess
+		}
+		fmt.Fprintf(w, `{"result":{"hash":%q,"status":%d}}`, req.Hash, status)
+	}
+}
+
+func TestServe(t *testing.T) {
+	alice := user{"fake:1", "alice"}
+	bob := user{"fake:2", "bob"}
+	carol := user{"fake:3", "carol"}
+
+	p := newFakePlatform(
+		mention{"10", alice, user{}, "@NebBot send 5 NAS", ""},
+		mention{"11", alice, bob, "@NebBot send 5 NAS, thanks", ""},
+		directMessage{alice, "no", ""},
+		directMessage{bob, "no", ""},
+		directMessage{bob, "transfer", ""},
+		mention{"12", carol, user{}, "@NebBot send 1 NAS to @dave", ""},
+		mention{"13", carol, bob, "@NebBot send five NAS", ""},
+	)
+	serve(p)
+
+	// confirmUserTx runs in its own goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	got := p.sent(alice.ID)
+	want := []string{`Who should I send it to? Reply to their post, or name them like "@NebBot send 5 NAS to @name".`, "CONFIRMATION: Send 5 NAS to @bob? (yes/NO)", "Transaction not sent."}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs to the sender were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	got = p.sent(carol.ID)
+	want = []string{"CONFIRMATION: Send 1 NAS to @dave? (yes/NO)", `Sorry, I couldn't read your tip: expected an amount, got "five".`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs to the sender of a named tip were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if c, _, _ := state.takeConfirmation(carol.ID, ""); c.Waiter.RecipientID != "fake:dave" {
+		t.Errorf("Named recipient was incorrect, got: %v, want: %v.\n", c.Waiter.RecipientID, "fake:dave")
+	}
+	if _, ok, _ := state.confirmation(alice.ID); ok {
+		t.Error("Cancelled confirmation is still pending.")
+	}
+
+	got = p.sent(bob.ID)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "To transfer NAS") {
+		t.Errorf("A \"no\" without a pending confirmation wasn't ignored, got: %q.\n", got)
+	}
+}
+
+func TestLoadBot(t *testing.T) {
+	for _, key := range []string{"", "zz", "abcd"} {
+		if _, err := loadBot(func(string) string { return key }); err == nil {
+			t.Errorf("Bot key %q was accepted.\n", key)
+		}
+	}
+	key := strings.Repeat("01", 32)
+	if a, err := loadBot(func(string) string { return key }); err != nil || a.addr == nil {
+		t.Errorf("Bot key wasn't loaded, got: %v.\n", err)
+	}
+}
+
+func TestLoadPlatforms(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+
+	ps, err := loadPlatforms(env(nil))
+	if err != nil || len(ps) != 1 || ps[0].Name() != "twitter" {
+		t.Errorf("Default platforms were incorrect, got: %v, %v.\n", ps, err)
+	}
+
+	_, err = loadPlatforms(env(map[string]string{"platforms": "twitter, myspace"}))
+	if err == nil {
+		t.Error("Unknown platform didn't throw an error.")
+	}
+
+	_, err = loadPlatforms(env(map[string]string{"platforms": "slack"}))
+	if err != errorSlackSecret {
+		t.Errorf("Slack without a signing secret was enabled, got: %v.\n", err)
+	}
+}
+
+func TestTwitterReplies(t *testing.T) {
+	var maxIDs []string
+	pages := []string{"search_1.json", "search_2.json", "search_3.json"}
+	search := func(v url.Values) (r anaconda.SearchResponse, err error) {
+		maxIDs = append(maxIDs, v.Get("max_id"))
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "twitter", pages[0]))
+		if len(pages) > 1 {
+			pages = pages[1:]
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &r)
+		}
+		return
+	}
+
+	replies, err := searchReplies(19, search)
+	want := []reply{
+		{user{"2", "bob"}, time.Date(2026, 10, 1, 12, 25, 0, 0, time.UTC)},
+		{user{"4", "dave"}, time.Date(2026, 10, 1, 12, 21, 0, 0, time.UTC)},
+	}
+	if err != nil || len(replies) != len(want) {
+		t.Fatalf("Replies were incorrect, got: %v, %v, want: %v.\n", replies, err, want)
+	}
+	for i := range want {
+		if replies[i].Author != want[i].Author || !replies[i].Posted.Equal(want[i].Posted) {
+			t.Errorf("Replies were incorrect, got: %v, want: %v.\n", replies, want)
+		}
+	}
+	if strings.Join(maxIDs, ",") != ",23,20" {
+		t.Errorf("Search didn't page back to the tweet, got max_id: %q.\n", maxIDs)
+	}
+
+	// The second page repeats forever, there's always an older tweet.
+	pages = []string{"search_1.json", "search_2.json"}
+	replies, err = searchReplies(19, search)
+	if err != errorRepliesTruncated || len(replies) != twitterSearchPages {
+		t.Errorf("Endless search wasn't reported as truncated, got: %d replies, %v.\n", len(replies), err)
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	var mu sync.Mutex
+	posted := map[string][]string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gateway", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(map[string]interface{}{"op": 10, "d": map[string]int{"heartbeat_interval": 45000}})
+
+		var identify struct {
+			Op int
+			D  struct{ Token string }
+		}
+		conn.ReadJSON(&identify)
+		if identify.Op != 2 || identify.D.Token != "token" {
+			t.Errorf("Identify was incorrect, got: %+v.\n", identify)
+		}
+
+		for i, d := range []string{
+			`"t":"READY","d":{"user":{"id":"900","username":"NebBot","bot":true}}`,
+			`"t":"MESSAGE_CREATE","d":{"id":"20","channel_id":"30","guild_id":"40","author":{"id":"1","username":"alice"},"content":"<@900> send 5 NAS","mentions":[{"id":"900","username":"NebBot"}],"referenced_message":{"id":"19","author":{"id":"2","username":"bob"}}}`,
+			`"t":"MESSAGE_CREATE","d":{"id":"21","channel_id":"30","guild_id":"40","author":{"id":"2","username":"bob"},"content":"send 5 NAS"}`,
+			`"t":"MESSAGE_CREATE","d":{"id":"22","channel_id":"31","author":{"id":"2","username":"bob"},"content":"help"}`,
+		} {
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"op":0,"s":%d,%s}`, i+1, d)))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4004, "Authentication failed"))
+		conn.ReadMessage()
+	})
+	mux.HandleFunc("/users/@me/channels", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RecipientID string `json:"recipient_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprintf(w, `{"id":"dm-%v"}`, req.RecipientID)
+	})
+	mux.HandleFunc("/channels/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bot token" {
+			w.WriteHeader(401)
+			return
+		}
+		var req struct {
+			Content string `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		channel := strings.Split(r.URL.Path, "/")[2]
+		mu.Lock()
+		posted[channel] = append(posted[channel], req.Content)
+		mu.Unlock()
+		w.Write([]byte("{}"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	env := map[string]string{
+		"discordToken":   "token",
+		"discordAPI":     srv.URL,
+		"discordGateway": "ws" + strings.TrimPrefix(srv.URL, "http") + "/gateway",
+	}
+	d := newDiscord(func(k string) string { return env[k] })
+	serve(d)
+	defer state.deleteConfirmation("discord:1")
+
+	// confirmUserTx runs in its own goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	err := d.Reply("30/20", "Rock on!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := map[string]string{
+		"dm-1": "CONFIRMATION: Send 5 NAS to @bob? (yes/NO)",
+		"dm-2": helpText,
+		"30":   "Rock on!",
+	}
+	if len(posted) != len(want) {
+		t.Errorf("Posted messages were incorrect, got: %q.\n", posted)
+	}
+	for channel, msg := range want {
+		if got := posted[channel]; len(got) != 1 || got[0] != msg {
+			t.Errorf("Messages in %v were incorrect, got: %q, want: %q.\n", channel, got, msg)
+		}
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	var mu sync.Mutex
+	sent := map[float64][]string{}
+	polls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bottoken/getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":900,"is_bot":true,"username":"NebTipBot"}}`))
+		case "/bottoken/getUpdates":
+			mu.Lock()
+			polls++
+			first := polls == 1
+			mu.Unlock()
+			if !first {
+				w.WriteHeader(401)
+				w.Write([]byte(`{"ok":false,"description":"Unauthorized"}`))
+				return
+			}
+			w.Write([]byte(`{"ok":true,"result":[
+				{"update_id":1,"message":{"message_id":20,"from":{"id":1,"username":"alice"},"chat":{"id":-40,"type":"group"},"text":"/tip 5 NAS","reply_to_message":{"message_id":19,"from":{"id":2,"username":"bob"},"chat":{"id":-40,"type":"group"},"text":"gm"}}},
+				{"update_id":2,"message":{"message_id":21,"from":{"id":2,"username":"bob"},"chat":{"id":-40,"type":"group"},"text":"nice"}},
+				{"update_id":3,"message":{"message_id":5,"from":{"id":2,"username":"bob"},"chat":{"id":2,"type":"private"},"text":"/help@NebTipBot"}}
+			]}`))
+		case "/bottoken/sendMessage":
+			var req struct {
+				ChatID float64 `json:"chat_id"`
+				Text   string  `json:"text"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			sent[req.ChatID] = append(sent[req.ChatID], req.Text)
+			mu.Unlock()
+			w.Write([]byte(`{"ok":true,"result":{}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	env := map[string]string{"telegramToken": "token", "telegramAPI": srv.URL}
+	tg := newTelegram(func(k string) string { return env[k] })
+	serve(tg)
+	defer state.deleteConfirmation("tg:1")
+
+	// confirmUserTx runs in its own goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	want := map[float64]string{
+		1: "CONFIRMATION: Send 5 NAS to @bob? (yes/NO)",
+		2: helpText,
+	}
+	if len(sent) != len(want) {
+		t.Errorf("Sent messages were incorrect, got: %v.\n", sent)
+	}
+	for chat, msg := range want {
+		if got := sent[chat]; len(got) != 1 || got[0] != msg {
+			t.Errorf("Messages to %v were incorrect, got: %q, want: %q.\n", chat, got, msg)
+		}
+	}
+	mu.Unlock()
+
+	u, err := tg.LookupUser("@Bob")
+	if err != nil || u.ID != "tg:2" {
+		t.Errorf("Looked up user was incorrect, got: %v, %v.\n", u, err)
+	}
+
+	// A Twitter ID must never reach a Telegram chat.
+	if err = tg.SendDM("2", "hi"); err != errorNotTelegramUser {
+		t.Errorf("Bare ID wasn't rejected, got: %v.\n", err)
+	}
+}
+
+// fixtureServer answers requests with recorded responses from testdata. Each route lists
+// the fixtures to serve in order, optionally prefixed with a status code, and repeats
+// the last one. Routes ending in "/" match every path below them.
+type fixtureServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	routes map[string][]string
+	bodies map[string][]string
+}
+
+func newFixtureServer(t *testing.T, dir string, routes map[string][]string) *fixtureServer {
+	f := &fixtureServer{routes: routes, bodies: map[string][]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		f.mu.Lock()
+		for route := range f.routes {
+			if strings.HasSuffix(route, "/") && strings.HasPrefix(key, route) {
+				key = route
+			}
+		}
+		fixtures, ok := f.routes[key]
+		if !ok {
+			f.mu.Unlock()
+			t.Errorf("Unexpected request %v.\n", key)
+			w.WriteHeader(404)
+			return
+		}
+		fixture := fixtures[0]
+		if len(fixtures) > 1 {
+			f.routes[key] = fixtures[1:]
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.bodies[key] = append(f.bodies[key], string(body))
+		f.mu.Unlock()
+
+		status := 200
+		if fields := strings.SplitN(fixture, " ", 2); len(fields) == 2 {
+			fmt.Sscan(fields[0], &status)
+			fixture = fields[1]
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", dir, fixture))
+		if err != nil {
+			t.Error(err)
+		}
+		if strings.HasSuffix(fixture, ".sse") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		w.Write(data)
+	}))
+	return f
+}
+
+// sent returns the string field name of every JSON body posted to route.
+func (f *fixtureServer) sent(route string, name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var values []string
+	for _, body := range f.bodies[route] {
+		var fields map[string]interface{}
+		json.Unmarshal([]byte(body), &fields)
+		if v, ok := fields[name].(string); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func TestMatrix(t *testing.T) {
+	srv := newFixtureServer(t, "matrix", map[string][]string{
+		"GET /_matrix/client/v3/account/whoami":                    {"whoami.json"},
+		"GET /_matrix/client/v3/sync":                              {"sync_initial.json", "sync.json", "401 unknown_token.json"},
+		"GET /_matrix/client/v3/rooms/!room:example.org/event/$gm": {"event_gm.json"},
+		"POST /_matrix/client/v3/createRoom":                       {"create_room.json"},
+		"PUT /_matrix/client/v3/rooms/!dm:example.org/send/":       {"send.json"},
+		"PUT /_matrix/client/v3/rooms/!alice:example.org/send/":    {"send.json"},
+		"PUT /_matrix/client/v3/rooms/!room:example.org/send/":     {"send.json"},
+	})
+	defer srv.Close()
+
+	env := map[string]string{"matrixHomeserver": srv.URL, "matrixToken": "token"}
+	m := newMatrix(func(k string) string { return env[k] })
+	serve(m)
+	defer state.deleteConfirmation("matrix:@alice:example.org")
+
+	// confirmUserTx runs in its own goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	err := m.Reply("!room:example.org/$tip", "Rock on!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	want := map[string]string{
+		"PUT /_matrix/client/v3/rooms/!dm:example.org/send/":    helpText,
+		"PUT /_matrix/client/v3/rooms/!alice:example.org/send/": "CONFIRMATION: Send 5 NAS to @bob:example.org? (yes/NO)",
+		"PUT /_matrix/client/v3/rooms/!room:example.org/send/":  "Rock on!",
+	}
+	for route, msg := range want {
+		if got := srv.sent(route, "body"); len(got) != 1 || got[0] != msg {
+			t.Errorf("Messages sent to %v were incorrect, got: %q, want: %q.\n", route, got, msg)
+		}
+	}
+	if got := srv.sent("POST /_matrix/client/v3/createRoom", "preset"); len(got) != 1 {
+		t.Errorf("DM room wasn't created, got: %q.\n", got)
+	}
+}
+
+func TestMastodon(t *testing.T) {
+	srv := newFixtureServer(t, "mastodon", map[string][]string{
+		"GET /api/v1/accounts/verify_credentials": {"verify_credentials.json"},
+		"GET /api/v1/streaming/user/notification": {"notifications.sse", "401 unauthorized.json"},
+		"POST /api/v1/statuses":                   {"status.json"},
+		"GET /api/v1/statuses/19/context":         {"context.json"},
+	})
+	defer srv.Close()
+
+	env := map[string]string{"mastodonServer": srv.URL, "mastodonToken": "token"}
+	m := newMastodon(func(k string) string { return env[k] })
+	serve(m)
+	defer state.deleteConfirmation("mastodon:1")
+
+	// confirmUserTx runs in its own goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	err := m.Reply("20", "Rock on!")
+	if err != nil {
+		t.Error(err)
+	}
+
+	got := srv.sent("POST /api/v1/statuses", "status")
+	want := []string{
+		"@alice CONFIRMATION: Send 5 NAS to @bob? (yes/NO)",
+		"@bob " + helpText,
+		"Rock on!",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Statuses were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	sort.Strings(got[:2])
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Statuses were incorrect, got: %q, want: %q.\n", got, want)
+		}
+	}
+
+	visibility := srv.sent("POST /api/v1/statuses", "visibility")
+	if len(visibility) != 2 || visibility[0] != "direct" || visibility[1] != "direct" {
+		t.Errorf("DMs weren't direct, got: %q.\n", visibility)
+	}
+
+	replies, err := m.Replies("19")
+	wantReplies := []reply{
+		{user{"mastodon:2", "bob"}, time.Date(2026, 10, 17, 12, 40, 0, 0, time.UTC)},
+		{user{"mastodon:4", "dave@example.social"}, time.Date(2026, 10, 17, 12, 42, 0, 0, time.UTC)},
+	}
+	if err != nil || fmt.Sprint(replies) != fmt.Sprint(wantReplies) {
+		t.Errorf("Replies were incorrect, got: %v, %v, want: %v.\n", replies, err, wantReplies)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var mu sync.Mutex
+	posted := map[string][]map[string]interface{}{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		posted[r.URL.Path] = append(posted[r.URL.Path], body)
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+
+	env := map[string]string{"slackToken": "token", "slackSigningSecret": "secret", "slackAPI": api.URL}
+	s := newSlack(func(k string) string { return env[k] })
+	events := make(chan interface{})
+	h := s.handler(events)
+
+	post := func(path string, form url.Values, ts time.Time, secret string) int {
+		body := form.Encode()
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "v0:%d:%s", ts.Unix(), body)
+		r.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(ts.Unix()))
+		r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	next := func() interface{} {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	tip := url.Values{"command": {"/nas"}, "text": {"tip <@U2|bob> 5"}, "user_id": {"U1"}, "user_name": {"alice"}, "channel_id": {"C1"}}
+	if code := post("/slack/commands", tip, time.Now(), "wrong"); code != 401 {
+		t.Errorf("Forged request wasn't rejected, got: %v.\n", code)
+	}
+	if code := post("/slack/commands", tip, time.Now().Add(-10*time.Minute), "secret"); code != 401 {
+		t.Errorf("Replayed request wasn't rejected, got: %v.\n", code)
+	}
+	h = newSlack(func(k string) string { return map[string]string{"slackAPI": api.URL}[k] }).handler(events)
+	if code := post("/slack/commands", tip, time.Now(), ""); code != 401 {
+		t.Errorf("Request signed with an empty secret wasn't rejected, got: %v.\n", code)
+	}
+	h = s.handler(events)
+
+	post("/slack/commands", tip, time.Now(), "secret")
+	m, _ := next().(mention)
+	want := mention{"C1", user{"slack:U1", "alice"}, user{"slack:U2", "bob"}, "@NebBot send 5 NAS", ""}
+	if m != want {
+		t.Errorf("Tip was incorrect, got: %+v, want: %+v.\n", m, want)
+	}
+
+	post("/slack/commands", url.Values{"text": {"balance"}, "user_id": {"U1"}, "user_name": {"alice"}}, time.Now(), "secret")
+	if d, _ := next().(directMessage); d.Text != "balance" || d.Sender.ID != "slack:U1" {
+		t.Errorf("Command was incorrect, got: %+v.\n", d)
+	}
+
+	confirmUserTx(s, m, tipCmd{Amount: uint128(5000000000000000000)})
+	defer state.deleteConfirmation("slack:U1")
+
+	mu.Lock()
+	prompt := posted["/chat.postMessage"]
+	mu.Unlock()
+	if len(prompt) != 1 || prompt[0]["channel"] != "U1" || len(prompt[0]["blocks"].([]interface{})) != 2 {
+		t.Fatalf("Confirmation wasn't sent with buttons, got: %v.\n", prompt)
+	}
+
+	c, _, _ := state.confirmation("slack:U1")
+	buttons := prompt[0]["blocks"].([]interface{})[1].(map[string]interface{})["elements"].([]interface{})
+	if v := buttons[1].(map[string]interface{})["value"]; v != "no:"+confirmationID(c) {
+		t.Errorf("Button value was incorrect, got: %v, want: %v.\n", v, "no:"+confirmationID(c))
+	}
+
+	click := func(value string) directMessage {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"type":         "block_actions",
+			"user":         map[string]string{"id": "U1", "username": "alice"},
+			"actions":      []map[string]string{{"action_id": "no", "value": value}},
+			"message":      map[string]string{"text": prompt[0]["text"].(string)},
+			"response_url": api.URL + "/response",
+		})
+		post("/slack/actions", url.Values{"payload": {string(payload)}}, time.Now(), "secret")
+		d, _ := next().(directMessage)
+		return d
+	}
+
+	if d := click("no"); d != (directMessage{}) {
+		t.Errorf("Button without a confirmation ID was taken, got: %+v.\n", d)
+	}
+	d := click("no:1")
+	if !confirmUserTxResponse(s, d) {
+		t.Errorf("Stale button click wasn't answered, got: %+v.\n", d)
+	}
+	if _, ok, _ := state.confirmation("slack:U1"); !ok {
+		t.Error("Stale button click cancelled the pending confirmation.")
+	}
+
+	d = click("no:" + confirmationID(c))
+	if !confirmUserTxResponse(s, d) {
+		t.Errorf("Button click wasn't taken as an answer, got: %+v.\n", d)
+	}
+	if _, ok, _ := state.confirmation("slack:U1"); ok {
+		t.Error("Cancelled confirmation is still pending.")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if r := posted["/response"]; len(r) != 2 || r[1]["replace_original"] != true {
+		t.Errorf("Buttons weren't replaced, got: %v.\n", r)
+	}
+	p := posted["/chat.postMessage"]
+	if len(p) != 3 || p[1]["text"] != "That confirmation is no longer pending." || p[2]["text"] != "Transaction not sent." {
+		t.Errorf("Answers were incorrect, got: %v.\n", p)
+	}
+}
+
+func TestState(t *testing.T) {
+	w := waiter{"10", "fake:1", "alice", "fake:2", "bob", "5000000000000000000", ""}
+	c := confirmation{Platform: "fake", Waiter: w, Deadline: time.Now().Add(time.Minute)}
+	if err := state.putConfirmation(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired, _ := state.expireConfirmation("fake:1", c.Deadline.Add(-time.Second)); expired {
+		t.Error("Newer confirmation was expired by an old deadline.")
+	}
+
+	if _, ok, _ := state.takeConfirmation("fake:1", "1"); ok {
+		t.Error("Confirmation was taken by an answer to another one.")
+	}
+	got, ok, err := state.takeConfirmation("fake:1", confirmationID(c))
+	if err != nil || !ok || got.Waiter != w || !got.Deadline.Equal(c.Deadline) {
+		t.Errorf("Confirmation was incorrect, got: %+v, %v, %v.\n", got, ok, err)
+	}
+	if _, ok, _ = state.takeConfirmation("fake:1", ""); ok {
+		t.Error("Confirmation was taken twice.")
+	}
+
+	c.Deadline = time.Now().Add(-time.Second)
+	state.putConfirmation(c)
+	if _, ok, _ = state.takeConfirmation("fake:1", ""); ok {
+		t.Error("Confirmation past its deadline was taken.")
+	}
+	if expired, _ := state.expireConfirmation("fake:1", c.Deadline); !expired {
+		t.Error("Confirmation past its deadline wasn't left to expire.")
+	}
+
+	id := fmt.Sprint("fake:", time.Now().UnixNano())
+	if ok, _ := state.reserveAddress(id, time.Minute); !ok {
+		t.Error("First address reservation was refused.")
+	}
+	if ok, _ := state.reserveAddress(id, time.Minute); ok {
+		t.Error("Second address reservation wasn't refused.")
+	}
+
+	// Amounts were stored as a number of NAS before they were exact.
+	old := fmt.Sprintf(`{"Platform":"fake","Waiter":{"StatusID":"11","SenderID":%q,"SenderName":"alice","RecipientID":"fake:2","RecipientName":"bob","Amount":0.25},"Deadline":"2030-01-01T00:00:00Z"}`, id)
+	state.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(confirmationsBucket).Put([]byte(id), []byte(old))
+	})
+	defer state.deleteConfirmation(id)
+	cs, err := state.confirmations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cs {
+		if c.Waiter.SenderID == id && c.Waiter.Amount != "250000000000000000" {
+			t.Errorf("Amount of an old confirmation was incorrect, got: %q.\n", c.Waiter.Amount)
+		}
+	}
+	if _, _, err := state.confirmation(id); err != nil {
+		t.Errorf("Old confirmation wasn't read, got: %v.\n", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	defer func(tr *txTracker) { tracker = tr }(tracker)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"hash":"abc","status":1}}`))
+	}))
+	defer srv.Close()
+	tracker = &txTracker{newRPCClient(srv.URL), time.Millisecond, time.Second}
+
+	alice := user{"fake:resume", "alice"}
+	w := waiter{"10", alice.ID, alice.Name, "fake:2", "bob", "5000000000000000000", ""}
+	state.putConfirmation(confirmation{Platform: "fake", Waiter: w, Deadline: time.Now().Add(-time.Second)})
+	state.putTx(submittedTx{Hash: "abc", Platform: "fake", SenderID: alice.ID, Announce: &w, Status: txPending, Submitted: time.Now()})
+	state.putTx(submittedTx{Hash: "def", Platform: "fake", SenderID: alice.ID, Status: txSuccess, Submitted: time.Now()})
+
+	p := newFakePlatform()
+	err := resume([]Platform{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	got := p.sent(alice.ID)
+	sort.Strings(got)
+	want := []string{"TIMEOUT: Defaulted to NO. Transaction not sent.", "Transaction confirmed. TX: abc"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("DMs after resuming were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	p.mu.Lock()
+	if r := p.replies["10"]; len(r) != 1 {
+		t.Errorf("Tip wasn't announced, got: %q.\n", r)
+	}
+	p.mu.Unlock()
+
+	tx, _, _ := state.tx("abc")
+	if tx.Status != txSuccess {
+		t.Errorf("Tx status was incorrect, got: %v, want: %v.\n", tx.Status, txSuccess)
+	}
+	if ts, _ := state.pendingTxs(); len(ts) != 0 {
+		t.Errorf("Txs are still pending, got: %+v.\n", ts)
+	}
+}
+
+func TestFormatNAS(t *testing.T) {
+	for wei, want := range map[string]string{
+		"0":                              "0",
+		"1":                              "0.000000000000000001",
+		"1500000000000000000":            "1.5",
+		"5000000000000000000":            "5",
+		"123456789012345678901234567890": "123456789012.34567890123456789",
+		"340282366920938463463374607431768211455": "340282366920938463463.374607431768211455",
+	} {
+		v, _ := util.NewUint128FromString(wei)
+		if got := formatNAS(v); got != want {
+			t.Errorf("Formatted %v wei was incorrect, got: %v, want: %v.\n", wei, got, want)
+		}
+	}
+}
+
+func TestParseNAS(t *testing.T) {
+	for in, want := range map[string]string{
+		"5":                    "5000000000000000000",
+		"0.123456789012345678": "123456789012345678",
+		"5 NAS":                "5000000000000000000",
+		"500 mNAS":             "500000000000000000",
+		"1e-3 nas":             "1000000000000000",
+		"2.5E2 µNAS":           "250000000000000",
+		".5":                   "500000000000000000",
+		"7 wei":                "7",
+		"1000000000000 NAS":    "1000000000000000000000000000000",
+		"340282366920938463463.374607431768211455": "340282366920938463463374607431768211455",
+	} {
+		got, err := parseNAS(in)
+		if err != nil || got.String() != want {
+			t.Errorf("Parsed %q was incorrect, got: %v, %v, want: %v.\n", in, got, err, want)
+		}
+	}
+
+	for in, want := range map[string]error{
+		"":                      errorInvalidAmount,
+		"five":                  errorInvalidAmount,
+		"1.2.3":                 errorInvalidAmount,
+		"-5":                    errorInvalidAmount,
+		"5 BTC":                 errorAmountUnit,
+		"0.0000000000000000001": errorAmountPrecision,
+		"1.5 wei":               errorAmountPrecision,
+		"0":                     errorAmountZero,
+		"340282366920938463464": util.ErrUint128Overflow,
+	} {
+		if got, err := parseNAS(in); err != want {
+			t.Errorf("Error of %q was incorrect, got: %v, %v, want: %v.\n", in, got, err, want)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"balance":"1500000000000000000","nonce":"0","type":87}}`))
+	}))
+	defer srv.Close()
+
+	defer func(n *rpcClient, k KeyStore) { node, keys = n, k }(node, keys)
+	node = newRPCClient(srv.URL)
+	keys = newMemoryStore()
+
+	alice := user{"fake:balance", "alice"}
+	p := newFakePlatform(directMessage{alice, "balance", ""})
+	serve(p)
+	time.Sleep(100 * time.Millisecond)
+
+	if got := p.sent(alice.ID); len(got) != 1 || got[0] != "Your balance is 1.5 NAS." {
+		t.Errorf("Balance was incorrect, got: %q.\n", got)
+	}
+
+	a, _ := keys.Get(alice.ID)
+	if u, ok, _ := state.watched(a.addr.String()); !ok || u.UserID != alice.ID {
+		t.Errorf("Address isn't watched for deposits, got: %+v.\n", u)
+	}
+}
+
+func TestDepositWatcher(t *testing.T) {
+	ours, _ := newAccount(nil)
+	alice := user{"fake:deposit", "alice"}
+	state.watchAddress(ours.addr.String(), watchedUser{"fake", alice.ID})
+	state.putTx(submittedTx{Hash: "tip", Platform: "fake", SenderID: "fake:2", Status: txSuccess})
+
+	height := 10
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/user/nebstate":
+			fmt.Fprintf(w, `{"result":{"height":"%d"}}`, height)
+		case "/v1/user/getBlockByHeight":
+			var req struct{ Height int }
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Height != 12 {
+				fmt.Fprintf(w, `{"result":{"height":"%d","transactions":[]}}`, req.Height)
+				return
+			}
+			fmt.Fprintf(w, `{"result":{"height":"12","transactions":[
+				{"hash":"deposit","from":"%[1]v","to":"%[2]v","value":"2000000000000000000","type":"binary","status":1},
+				{"hash":"tip","from":"%[1]v","to":"%[2]v","value":"1000000000000000000","type":"binary","status":1},
+				{"hash":"failed","from":"%[1]v","to":"%[2]v","value":"1000000000000000000","type":"binary","status":0},
+				{"hash":"other","from":"%[2]v","to":"%[1]v","value":"1000000000000000000","type":"binary","status":1}
+			]}}`, acc.addr, ours.addr)
+		}
+	}))
+	defer srv.Close()
+
+	d := &depositWatcher{newRPCClient(srv.URL), time.Millisecond}
+	p := newFakePlatform()
+	byName := map[string]Platform{"fake": p}
+
+	state.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(watcherBucket).Delete(scannedHeightKey) })
+	if err := d.scan(byName); err != nil {
+		t.Fatal(err)
+	}
+
+	height = 13
+	if err := d.scan(byName); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("You received 2 NAS from %v. TX: deposit", acc.addr)
+	if got := p.sent(alice.ID); len(got) != 1 || got[0] != want {
+		t.Errorf("Deposit DMs were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if h, _, _ := state.scannedHeight(); h != 13 {
+		t.Errorf("Scanned height was incorrect, got: %v, want: %v.\n", h, 13)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	n := time.Now().UnixNano()
+	alice := user{fmt.Sprint("fake:alice-", n), "alice"}
+	bob := user{fmt.Sprint("fake:bob-", n), "bob"}
+
+	first := ledgerEntry{Kind: ledgerTip, Platform: "fake", SenderID: alice.ID, SenderName: alice.Name, RecipientID: bob.ID, RecipientName: bob.Name,
+		Amount: "1500000000000000000", Hash: "tip-0", StatusID: "10", Status: txPending, Created: time.Unix(0, 0)}
+	if err := state.addLedger(&first); err != nil {
+		t.Fatal(err)
+	}
+	state.setLedgerStatus(first.Seq, txSuccess)
+	for i := 1; i < 11; i++ {
+		e := ledgerEntry{Kind: ledgerTip, Platform: "fake", SenderID: alice.ID, SenderName: alice.Name, RecipientID: bob.ID, RecipientName: bob.Name,
+			Amount: "1000000000000000000", Hash: fmt.Sprint("tip-", i), Status: txPending, Created: time.Unix(int64(i), 0)}
+		state.addLedger(&e)
+	}
+	state.addLedger(&ledgerEntry{Kind: ledgerTransfer, Platform: "fake", SenderID: alice.ID, SenderName: alice.Name,
+		To: "n1abc", Amount: "2000000000000000000", Hash: "transfer", Status: txFailed, Created: time.Unix(11, 0)})
+
+	p := newFakePlatform(
+		directMessage{alice, "history", ""},
+		directMessage{alice, "history 2", ""},
+		directMessage{alice, "export history", ""},
+		directMessage{bob, "History", ""},
+		directMessage{user{"fake:nobody", "nobody"}, "history", ""},
+	)
+	serve(p)
+	time.Sleep(100 * time.Millisecond)
+
+	got := p.sent(alice.ID)
+	if len(got) != 4 {
+		t.Fatalf("History DMs were incorrect, got: %q.\n", got)
+	}
+
+	lines := strings.Split(got[0], "\n")
+	if len(lines) != 11 || lines[0] != "1970-01-01 00:00 sent 2 NAS to n1abc (failed) TX: transfer" || lines[10] != `Page 1 of 2. Type "history 2" for older entries.` {
+		t.Errorf("First history page was incorrect, got: %q.\n", lines)
+	}
+	lines = strings.Split(got[1], "\n")
+	if len(lines) != 3 || lines[1] != "1970-01-01 00:00 sent 1.5 NAS to @bob (success) TX: tip-0" || lines[2] != "Page 2 of 2." {
+		t.Errorf("Second history page was incorrect, got: %q.\n", lines)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(got[3])).ReadAll()
+	if err != nil || len(records) != 13 {
+		t.Fatalf("CSV export was incorrect, got: %q, %v.\n", got[3], err)
+	}
+	want := []string{"1970-01-01T00:00:00Z", "tip", "sent", "@bob", "1.5", "tip-0", "success", "fake", "10", ""}
+	if strings.Join(records[12], ",") != strings.Join(want, ",") {
+		t.Errorf("CSV row was incorrect, got: %v, want: %v.\n", records[12], want)
+	}
+
+	if got := p.sent(bob.ID); len(got) != 1 || !strings.HasPrefix(got[0], "1970-01-01 00:00 received 1 NAS from @alice (pending) TX: tip-10\n") {
+		t.Errorf("Recipient history was incorrect, got: %q.\n", got)
+	}
+	if got := p.sent("fake:nobody"); len(got) != 1 || got[0] != "You haven't sent or received anything through me yet." {
+		t.Errorf("Empty history was incorrect, got: %q.\n", got)
+	}
+}
+
+func TestEscrow(t *testing.T) {
+	fn := newFakeNode("escrow")
+	defer fn.close()
+
+	n := time.Now().UnixNano()
+	alice := user{fmt.Sprint("fake:alice-", n), "alice"}
+	bob := user{fmt.Sprint("fake:bob-", n), "bob"}
+	carol := user{fmt.Sprint("fake:carol-", n), "carol"}
+
+	p := newFakePlatform(
+		mention{"20", alice, bob, "@NebBot send 2 NAS", ""},
+		directMessage{alice, "yes", ""},
+		mention{"21", alice, carol, "@NebBot send 3 NAS", ""},
+		directMessage{alice, "yes", ""},
+	)
+	serve(p)
+	p.waitSent(alice.ID, 8)
+	p.waitSent(carol.ID, 1)
+
+	if got := p.waitSent(bob.ID, 1); len(got) != 1 || got[0] != `@alice sent you 2 NAS. DM me "claim" within 7 days to receive it.` {
+		t.Errorf("Recipient wasn't told to claim, got: %q.\n", got)
+	}
+	if r := p.waitReplies("20", 1); len(r) != 1 || !strings.Contains(r[0], `sent 2 NAS to @bob. DM me "claim" within 7 days`) {
+		t.Errorf("Escrowed tip wasn't announced, got: %q.\n", r)
+	}
+	if _, err := keys.Get(bob.ID); err != errorNotInStorage {
+		t.Errorf("A key was created for the recipient of an escrowed tip, got: %v.\n", err)
+	}
+
+	p = newFakePlatform(directMessage{bob, "claim", ""}, directMessage{bob, "claim", ""})
+	serve(p)
+	p.waitSent(alice.ID, 1)
+
+	if _, err := keys.Get(bob.ID); err != nil {
+		t.Errorf("Claim didn't create a key for the recipient, got: %v.\n", err)
+	}
+	want := []string{"There's nothing for you to claim.", "Transaction confirmed. TX: escrow-3"}
+	got := p.waitSent(bob.ID, len(want))
+	sort.Strings(got)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs to the claimer were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if got := p.sent(alice.ID); len(got) != 1 || got[0] != "@bob claimed the 2 NAS you sent them." {
+		t.Errorf("Sender wasn't told about the claim, got: %q.\n", got)
+	}
+
+	p = newFakePlatform()
+	if err := escrows.refund(map[string]Platform{"fake": p}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.sent(alice.ID); len(got) != 0 {
+		t.Errorf("Escrow was refunded early, got: %q.\n", got)
+	}
+	if err := escrows.refund(map[string]Platform{"fake": p}, time.Now().Add(escrowPeriod)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"@carol didn't claim the 3 NAS you sent them within 7 days, so I'm sending back 2.999999976 NAS after fees.", "Transaction confirmed. TX: escrow-4"}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Refund DMs were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if es, _ := state.heldEscrows(); len(es) != 0 {
+		t.Errorf("Escrows are still held, got: %+v.\n", es)
+	}
+
+	p = newFakePlatform(mention{"22", alice, carol, "@NebBot send 0.0001 NAS", ""}, directMessage{alice, "yes", ""})
+	serve(p)
+	want = []string{"CONFIRMATION: Send 0.0001 NAS to @carol? (yes/NO)", "Starting transaction...", "Transaction failed.\nReason: " + errorEscrowMinimum.Error()}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Escrow below the minimum wasn't refused, got: %q, want: %q.\n", got, want)
+	}
+
+	entries, _, _ := state.ledger(alice.ID, 0, 0)
+	var kinds []string
+	for _, e := range entries {
+		kinds = append(kinds, e.Kind+" "+e.Hash+" "+e.Status)
+	}
+	want = []string{"refund escrow-4 success", "expiry escrow-2 refunded", "escrow escrow-2 success", "escrow escrow-1 success"}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("Sender's ledger was incorrect, got: %q, want: %q.\n", kinds, want)
+	}
+	entries, _, _ = state.ledger(bob.ID, 0, 0)
+	if len(entries) != 1 || entries[0].Kind != ledgerClaim || entries[0].SenderName != "alice" || entries[0].Amount != "1999999976000000000" {
+		t.Errorf("Recipient's ledger was incorrect, got: %+v.\n", entries)
+	}
+
+	// The bot stopped waiting for these before they were executed, the node knows better now.
+	erin := user{fmt.Sprint("fake:erin-", n), "erin"}
+	fn.mu.Lock()
+	fn.status["late-2"] = core.TxExecutionPendding
+	fn.mu.Unlock()
+	for _, hash := range []string{"late-1", "late-2"} {
+		e := escrow{Platform: "fake", Waiter: waiter{"23", erin.ID, "erin", carol.ID, "carol", "1000000000000000000", ""}, Hash: hash, Status: escrowHeld}
+		state.addEscrow(&e)
+		state.putTx(submittedTx{Hash: hash, Platform: "fake", SenderID: erin.ID, Status: txUnknown})
+	}
+	p = newFakePlatform()
+	if err := escrows.refund(map[string]Platform{"fake": p}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"@carol didn't claim the 1 NAS you sent them within 7 days, so I'm sending back 0.999999976 NAS after fees.", "Transaction confirmed. TX: escrow-5"}
+	if got := p.waitSent(erin.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Escrow funded after the bot stopped waiting wasn't refunded, got: %q, want: %q.\n", got, want)
+	}
+	es, _ := state.heldEscrows()
+	if len(es) != 1 || es[0].Hash != "late-2" {
+		t.Fatalf("Escrow still being funded wasn't left held, got: %+v.\n", es)
+	}
+	state.setEscrowStatus(es[0].ID, escrowHeld, escrowRefunded)
+}
+
+func TestSplitAmount(t *testing.T) {
+	for _, c := range []struct {
+		total string
+		n     int
+		want  string
+	}{
+		{"10000000000000000000", 3, "3333333333333333334 3333333333333333333 3333333333333333333"},
+		{"10", 4, "3 3 2 2"},
+		{"9", 3, "3 3 3"},
+		{"5", 5, "1 1 1 1 1"},
+		{"340282366920938463463374607431768211455", 2, "170141183460469231731687303715884105728 170141183460469231731687303715884105727"},
+	} {
+		total, _ := util.NewUint128FromString(c.total)
+		shares, err := splitAmount(total, c.n)
+		var got []string
+		for _, s := range shares {
+			got = append(got, s.String())
+		}
+		if err != nil || strings.Join(got, " ") != c.want {
+			t.Errorf("Split of %v between %v was incorrect, got: %v, %v, want: %v.\n", c.total, c.n, got, err, c.want)
+		}
+	}
+
+	if _, err := splitAmount(uint128(2), 3); err != errorAmountTooSmall {
+		t.Errorf("Split below 1 wei each wasn't refused, got: %v.\n", err)
+	}
+}
+
+func TestParseRain(t *testing.T) {
+	for _, c := range []struct {
+		text, amount, recipients, memo string
+	}{
+		{"@NebBot rain 10 NAS on @a @b @c", "10000000000000000000", "a b c", ""},
+		{"@NebBot rain 10 on @a, @b and @c!", "10000000000000000000", "a b", "and @c!"},
+		{"@NebBot airdrop 1 NAS", "1000000000000000000", "", ""},
+		{"@NebBot airdrop 500 mNAS thanks everyone", "500000000000000000", "", "thanks everyone"},
+		{"@NebBot RAIN 2 nas @a gm", "2000000000000000000", "a", "gm"},
+	} {
+		cmd, err := parseRain(c.text)
+		if err != nil || cmd.Amount.String() != c.amount || strings.Join(cmd.Recipients, " ") != c.recipients || cmd.Memo != c.memo {
+			t.Errorf("Rain %q was incorrect, got: %+v, %v, want: %v, %q, %q.\n", c.text, cmd, err, c.amount, c.recipients, c.memo)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"@NebBot send 5 NAS to @bob": errorNotCommand.Error(),
+		"@NebBot rain on @a":         `expected an amount, got "on"`,
+		"@NebBot rain 5 NAS on":      "expected an @handle",
+		"@NebBot rain 5 NAS to all":  `expected an @handle, got "all"`,
+		"@NebBot rain 5 everyone":    `expected a unit (NAS, mNAS, µNAS or wei) or an @handle, got "everyone"`,
+	} {
+		if _, err := parseRain(text); err == nil || err.Error() != want {
+			t.Errorf("Error of %q was incorrect, got: %v, want: %v.\n", text, err, want)
+		}
+	}
+}
+
+// fakeReplies is a fakePlatform that can list replies, and doesn't know "nobody".
+// The repliers replied long ago, the late ones just now.
+type fakeReplies struct {
+	*fakePlatform
+	repliers []user
+	late     []user
+}
+
+func (f *fakeReplies) Replies(statusID string) ([]reply, error) {
+	var rs []reply
+	for _, u := range f.repliers {
+		rs = append(rs, reply{u, time.Unix(0, 0)})
+	}
+	for _, u := range f.late {
+		rs = append(rs, reply{u, time.Now()})
+	}
+	return rs, nil
+}
+
+func (f *fakeReplies) LookupUser(name string) (user, error) {
+	if name == "nobody" {
+		return user{}, errorUnknownUser
+	}
+	return f.fakePlatform.LookupUser(name)
+}
+
+func TestRain(t *testing.T) {
+	fn := newFakeNode("rain")
+	defer fn.close()
+	fn.refuse[2] = true
+
+	n := time.Now().UnixNano()
+	alice := user{fmt.Sprint("fake:alice-", n), "alice"}
+	bob, carol, dave := fmt.Sprint("bob", n), fmt.Sprint("carol", n), fmt.Sprint("dave", n)
+	getAcc("fake:" + bob)
+	getAcc("fake:" + carol)
+	defer func() {
+		// Don't leave the escrowed share for other tests to refund.
+		es, _ := state.heldEscrows()
+		for _, e := range es {
+			if e.Waiter.SenderID == alice.ID {
+				state.setEscrowStatus(e.ID, escrowHeld, escrowRefunded)
+			}
+		}
+	}()
+
+	p := &fakeReplies{newFakePlatform(
+		mention{"30", alice, user{}, fmt.Sprintf("@NebBot rain 10 NAS on @%v @%v @nobody @%v @%v", bob, carol, dave, bob), ""},
+		directMessage{alice, "yes", ""},
+	), nil, nil}
+	serve(p)
+
+	want := []string{
+		fmt.Sprintf("CONFIRMATION: Send 10 NAS split between @%v, @%v and @%v? (yes/NO)\nI don't know @nobody, they're left out.", bob, carol, dave),
+		"Starting transactions...",
+		fmt.Sprintf("Rain of 10 NAS: 2 of 3 transfers sent.\n@%v: sent 3.333333333333333334 NAS. TX: rain-1\n@%v: failed. Reason: node error (/v1/user/rawtransaction 400): invalid transaction\n@%v: sent 3.333333333333333333 NAS. TX: rain-3", bob, carol, dave),
+	}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs to the sender were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if got := p.waitSent("fake:"+dave, 1); len(got) != 1 || !strings.HasPrefix(got[0], "@alice sent you 3.333333333333333333 NAS.") {
+		t.Errorf("Escrowed share wasn't announced to its recipient, got: %q.\n", got)
+	}
+	if r := p.waitReplies("30", 2); len(r) != 2 || !strings.HasSuffix(r[0], fmt.Sprintf("@alice made it rain on @%v and @%v!", bob, dave)) {
+		t.Errorf("Rain wasn't announced, got: %q.\n", r)
+	}
+
+	entries, _, _ := state.ledger(alice.ID, 0, 0)
+	if len(entries) != 2 || entries[0].Kind != ledgerEscrow || entries[1].Kind != ledgerTip || entries[1].RecipientID != "fake:"+bob {
+		t.Errorf("Rain ledger was incorrect, got: %+v.\n", entries)
+	}
+	state.deleteConfirmation(alice.ID)
+
+	p = &fakeReplies{newFakePlatform(
+		mention{"31", alice, user{}, "@NebBot airdrop 1 NAS", "29"},
+		mention{"32", alice, user{}, "@NebBot airdrop 1 NAS", ""},
+	), []user{{"fake:erin", "erin"}, {alice.ID, "alice"}, {"fake:erin", "erin"}, {"fake:frank", "frank"}}, nil}
+	serve(p)
+	defer state.deleteConfirmation(alice.ID)
+
+	want = []string{
+		"CONFIRMATION: Send 1 NAS split between @erin and @frank? (yes/NO)",
+		`To make it rain, name the recipients like "@NebBot rain 10 NAS on @alice @bob", or reply to a post to share it among everyone who replied to that post.`,
+	}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about airdrops were incorrect, got: %q, want: %q.\n", got, want)
+	}
+}
+
+func TestParseGiveaway(t *testing.T) {
+	for _, c := range []struct {
+		text, amount string
+		winners      int
+		memo         string
+	}{
+		{"@NebBot giveaway 10 NAS", "10000000000000000000", 1, ""},
+		{"@NebBot giveaway 10 NAS to 3 winners", "10000000000000000000", 3, ""},
+		{"@NebBot raffle 10 1 winner good luck!", "10000000000000000000", 1, "good luck!"},
+		{"@NebBot GIVEAWAY 500 mNAS happy friday", "500000000000000000", 1, "happy friday"},
+	} {
+		cmd, err := parseGiveaway(c.text)
+		if err != nil || cmd.Amount.String() != c.amount || cmd.Winners != c.winners || cmd.Memo != c.memo {
+			t.Errorf("Giveaway %q was incorrect, got: %+v, %v, want: %v, %v, %q.\n", c.text, cmd, err, c.amount, c.winners, c.memo)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"@NebBot rain 5 NAS on @bob":          errorNotCommand.Error(),
+		"@NebBot giveaway":                    "expected an amount",
+		"@NebBot giveaway 5 NAS to everyone":  `expected a number of winners, got "everyone"`,
+		"@NebBot giveaway 5 NAS to 0 winners": `expected a number of winners, got "0"`,
+		"@NebBot giveaway 5 to all":           `expected a number of winners, got "all"`,
+		"@NebBot giveaway 5 everyone":         `expected a unit (NAS, mNAS, µNAS or wei) or a number of winners, got "everyone"`,
+	} {
+		if _, err := parseGiveaway(text); err == nil || err.Error() != want {
+			t.Errorf("Error of %q was incorrect, got: %v, want: %v.\n", text, err, want)
+		}
+	}
+}
+
+func TestGiveaway(t *testing.T) {
+	entrants := []string{"fake:c", "fake:a", "fake:b", "fake:d"}
+	winners, proof, err := drawGiveaway(acc, "40", "abcd", entrants, 2)
+	if err != nil || len(winners) != 2 || winners[0] == winners[1] {
+		t.Fatalf("Draw was incorrect, got: %v, %v.\n", winners, err)
+	}
+	pub, _ := acc.priv.PublicKey().Encoded()
+	got, err := verifyGiveaway(pub, "40", "abcd", proof, []string{"fake:d", "fake:b", "fake:a", "fake:c"}, 2)
+	if err != nil || strings.Join(got, " ") != strings.Join(winners, " ") {
+		t.Errorf("Verified winners were incorrect, got: %v, %v, want: %v.\n", got, err, winners)
+	}
+	if _, err := verifyGiveaway(pub, "40", "abce", proof, entrants, 2); err == nil {
+		t.Error("Proof for another block was accepted.")
+	}
+	if _, err := verifyGiveaway([]byte{4, 1, 2}, "40", "abcd", proof, entrants, 2); err != errorInvalidVRFKey {
+		t.Errorf("Invalid key wasn't rejected, got: %v.\n", err)
+	}
+	if got := pickWinners([32]byte{}, entrants, 9); len(got) != len(entrants) {
+		t.Errorf("Everyone should win when there are fewer entrants than winners, got: %v.\n", got)
+	}
+	ids := make([]string, 40)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("fake:%02d", 39-i)
+	}
+	texts := entrantsReplies(ids)
+	if len(texts) != 2 || len(texts[0]) > replyMaxLen || !strings.HasPrefix(texts[0], "Entrants: fake:00 fake:01 ") || !strings.HasSuffix(texts[1], " fake:39") {
+		t.Errorf("Entrants replies were incorrect, got: %q.\n", texts)
+	}
+
+	fn := newFakeNode("giveaway")
+	defer fn.close()
+
+	alice := user{"fake:alice", "alice"}
+	repliers := []user{{"fake:erin", "erin"}, alice, {"fake:erin", "erin"}, {"fake:frank", "frank"}, {"fake:grace", "grace"}}
+	for _, u := range repliers {
+		getAcc(u.ID)
+	}
+
+	p := &fakeReplies{newFakePlatform(
+		mention{"40", alice, user{}, "@NebBot giveaway 2 NAS to 2 winners", ""},
+		directMessage{alice, "yes", ""},
+	), repliers, []user{{"fake:heidi", "heidi"}}}
+	serve(p)
+
+	want := []string{"CONFIRMATION: Give away 2 NAS to 2 winners among the replies to your post? (yes/NO)", "Your giveaway is open until block 5860, about 1 day from now."}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about the giveaway were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	gw := &giveawayWatcher{node, time.Millisecond}
+	if err := gw.draw(map[string]Platform{"fake": p}); err != nil {
+		t.Fatal(err)
+	}
+	if gs, _ := state.openGiveaways(); len(gs) != 1 {
+		t.Fatalf("Giveaway was drawn before its closing block, got: %+v.\n", gs)
+	}
+	fn.mu.Lock()
+	fn.height = 5860
+	fn.mu.Unlock()
+	if err := gw.draw(map[string]Platform{"fake": p}); err != nil {
+		t.Fatal(err)
+	}
+	got = p.waitSent(alice.ID, 3)
+	if gs, _ := state.openGiveaways(); len(gs) != 0 {
+		t.Errorf("Giveaway is still open, got: %+v.\n", gs)
+	}
+	if len(got) != 3 || !strings.HasPrefix(got[2], "Giveaway of 2 NAS: 2 of 2 transfers sent.\n") {
+		t.Errorf("Giveaway summary was incorrect, got: %q.\n", got)
+	}
+
+	r := p.waitReplies("40", 5)
+	if len(r) != 5 || !strings.HasPrefix(r[0], "@alice is giving away 2 NAS to 2 winners!") || !strings.Contains(r[1], "drawn among 3 entries at block 5860") {
+		t.Fatalf("Giveaway wasn't announced, got: %q.\n", r)
+	}
+	if r[4] != "Entrants: fake:erin fake:frank fake:grace" {
+		t.Errorf("Entrants weren't published, or a reply after the closing block was entered, got: %q.\n", r[4])
+	}
+	proof, _ = hex.DecodeString(strings.TrimPrefix(r[2], "VRF proof: "))
+	pub, _ = hex.DecodeString(strings.TrimPrefix(r[3], "VRF key: "))
+	winners, err = verifyGiveaway(pub, "40", "c0ffee", proof, strings.Fields(strings.TrimPrefix(r[4], "Entrants:")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyGiveaway(pub, "40", "c0ffee", proof, []string{"fake:erin", "fake:frank", "fake:heidi"}, 2); err == nil {
+		t.Error("Proof checked out with other entrants.")
+	}
+	for _, id := range winners {
+		if !strings.Contains(r[1], "@"+strings.TrimPrefix(id, "fake:")) {
+			t.Errorf("Published proof doesn't draw the announced winners, got: %v, announced: %q.\n", winners, r[1])
+		}
+	}
+}
+
+func TestVerifyGiveawayCmd(t *testing.T) {
+	// Anyone can check a draw, without the bot's key or key store.
+	defer func(b, k error) { errorBotKey, errorKeyStore = b, k }(errorBotKey, errorKeyStore)
+	errorBotKey = errors.New("bot must be set")
+	errorKeyStore = errors.New("unknown key store")
+
+	entrants := []string{"fake:a", "fake:b", "fake:c"}
+	winners, proof, err := drawGiveaway(acc, "40", "abcd", entrants, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := acc.priv.PublicKey().Encoded()
+	args := append([]string{hex.EncodeToString(pub), "40", "abcd", hex.EncodeToString(proof), "1"}, entrants...)
+
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	code := runCommand("verify-giveaway", args)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	if code != 0 || strings.TrimSpace(string(out)) != winners[0] {
+		t.Errorf("verify-giveaway was incorrect, got: %d, %q, want: %v.\n", code, out, winners)
+	}
+
+	os.Stdout, _ = os.Open(os.DevNull)
+	code = runCommand("export-key", []string{"fake:a"})
+	os.Stdout = stdout
+	if code != 1 {
+		t.Errorf("export-key ran without the bot's key, got: %d.\n", code)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	fn := newFakeNode("scheduled")
+	defer fn.close()
+
+	n := time.Now().UnixNano()
+	alice := user{fmt.Sprint("fake:alice-", n), "alice"}
+	bob := fmt.Sprint("bob", n)
+	getAcc("fake:" + bob)
+	addr := acc.addr.String()
+
+	p := newFakePlatform(
+		directMessage{alice, fmt.Sprintf("schedule 1 NAS to @%v every week thanks", bob), ""},
+		directMessage{alice, "schedule 2 NAS to " + addr + " in 2 hours", ""},
+	)
+	serve(p)
+	p.waitSent(alice.ID, 2)
+
+	ss, _ := state.schedules(alice.ID)
+	if len(ss) != 2 || ss[0].Waiter.RecipientID != "fake:"+bob || ss[0].Waiter.Memo != "thanks" || ss[1].To != addr {
+		t.Fatalf("Schedules were incorrect, got: %+v.\n", ss)
+	}
+	weekly, once := ss[0], ss[1]
+	want := []string{
+		fmt.Sprintf(`Scheduled 1 NAS to @%v every 7 days, first on %v. It's number %d, DM me "schedule cancel %d" to stop it.`, bob, formatTime(weekly.Next), weekly.ID, weekly.ID),
+		fmt.Sprintf(`Scheduled 2 NAS to %v on %v. It's number %d, DM me "schedule cancel %d" to stop it.`, addr, formatTime(once.Next), once.ID, once.ID),
+	}
+	if got := p.sent(alice.ID); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about scheduling were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	p = newFakePlatform()
+	byName := map[string]Platform{"fake": p}
+	now := weekly.Next.Add(time.Minute)
+	if err := schedules.send(byName, now); err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{
+		fmt.Sprintf("Scheduled tip %d: sending 1 NAS to @%v. TX: scheduled-1\nNext on %v.", weekly.ID, bob, formatTime(weekly.Next.Add(7*24*time.Hour))),
+		fmt.Sprintf("Scheduled tip %d: sending 2 NAS to %v. TX: scheduled-2", once.ID, addr),
+		"Transaction confirmed. TX: scheduled-1",
+		"Transaction confirmed. TX: scheduled-2",
+	}
+	got := p.waitSent(alice.ID, len(want))
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Receipts were incorrect, got: %q, want: %q.\n", got, want)
+	}
+	if entries, _, _ := state.ledger(alice.ID, 0, 0); len(entries) != 2 || entries[0].Kind != ledgerTransfer || entries[1].Kind != ledgerTip || entries[1].Memo != "thanks" {
+		t.Errorf("Scheduled tips weren't recorded in the ledger, got: %+v.\n", entries)
+	}
+	if ss, _ := state.schedules(alice.ID); len(ss) != 1 {
+		t.Errorf("Tip that runs once is still scheduled, got: %+v.\n", ss)
+	}
+
+	fn.mu.Lock()
+	fn.balance = "1000"
+	fn.mu.Unlock()
+	p = newFakePlatform()
+	byName["fake"] = p
+	now = now.Add(7 * 24 * time.Hour)
+	schedules.send(byName, now)
+	schedules.send(byName, now)
+	want = []string{fmt.Sprintf(`Scheduled tip %d of 1 NAS to @%v is paused, your balance is too low. DM me "schedule resume %d" once you've topped up.`, weekly.ID, bob, weekly.ID)}
+	if got := p.sent(alice.ID); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about a low balance were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	fn.mu.Lock()
+	fn.balance = "100000000000000000000"
+	fn.mu.Unlock()
+	p = newFakePlatform(
+		directMessage{alice, "schedule list", ""},
+		directMessage{alice, fmt.Sprint("schedule resume ", weekly.ID), ""},
+	)
+	serve(p)
+	p.waitSent(alice.ID, 2)
+	byName["fake"] = p
+	schedules.send(byName, now)
+
+	want = []string{
+		fmt.Sprintf("Your scheduled tips:\n%d. 1 NAS to @%v every 7 days, paused", weekly.ID, bob),
+		fmt.Sprintf("Resumed scheduled tip %d of 1 NAS to @%v.", weekly.ID, bob),
+		fmt.Sprintf("Scheduled tip %d: sending 1 NAS to @%v. TX: scheduled-3\nNext on %v.", weekly.ID, bob, formatTime(weekly.Next.Add(14*24*time.Hour))),
+		"Transaction confirmed. TX: scheduled-3",
+	}
+	if got = p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs after resuming were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	p = newFakePlatform(
+		directMessage{user{"fake:mallory", "mallory"}, fmt.Sprint("schedule cancel ", weekly.ID), ""},
+		directMessage{alice, fmt.Sprint("schedule cancel ", weekly.ID), ""},
+		directMessage{alice, "schedules", ""},
+	)
+	serve(p)
+	if got := p.waitSent("fake:mallory", 1); len(got) != 1 || got[0] != fmt.Sprintf("You have no scheduled tip number %d.", weekly.ID) {
+		t.Errorf("Someone else's schedule could be cancelled, got: %q.\n", got)
+	}
+	want = []string{
+		fmt.Sprintf("Cancelled scheduled tip %d of 1 NAS to @%v.", weekly.ID, bob),
+		`You have no scheduled tips. Schedule one like "schedule 1 NAS to @name every week".`,
+	}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about cancelling were incorrect, got: %q, want: %q.\n", got, want)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if _, err := loadLimits(func(string) string { return "lots" }); err == nil || !strings.HasPrefix(err.Error(), "invalid ") {
+		t.Errorf("Invalid limit was accepted, got: %v.\n", err)
+	}
+	env := map[string]string{"txLimit": "10 NAS", "dailyLimit": "15", "codeAbove": "5 NAS"}
+	l, err := loadLimits(func(k string) string { return env[k] })
+	if err != nil || l.PerTx != "10000000000000000000" || l.Daily != "15000000000000000000" || l.Code != "5000000000000000000" {
+		t.Fatalf("Limits were incorrect, got: %+v, %v.\n", l, err)
+	}
+	defer func(l limits) { botLimits = l }(botLimits)
+	botLimits = l
+
+	fn := newFakeNode("limited")
+	defer fn.close()
+
+	n := time.Now().UnixNano()
+	alice := user{fmt.Sprint("fake:alice-", n), "alice"}
+	bob := user{fmt.Sprint("fake:bob-", n), "bob"}
+	getAcc(bob.ID)
+
+	// code waits for the bot to ask alice for a one-time code in their nth DM, and returns it.
+	code := func(p *fakePlatform, n int) string {
+		got := p.waitSent(alice.ID, n)
+		last := got[len(got)-1]
+		want := "That's a large amount. To send 8 NAS, type this code within 5 minutes: "
+		if !strings.HasPrefix(last, want) {
+			t.Fatalf("Code wasn't asked for, got: %q.\n", got)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(last, want), "\nType \"no\" to cancel.")
+	}
+
+	p := newFakePlatform(
+		mention{"1", alice, bob, "@NebBot send 20 NAS", ""},
+		mention{"2", alice, bob, "@NebBot send 8 NAS", ""},
+		directMessage{alice, "yes", ""},
+	)
+	serve(p)
+	wrong := []byte(code(p, 3))
+	if got := p.sent(alice.ID); got[0] != "Sorry, 20 NAS is over your limit of 10 NAS per transaction." {
+		t.Errorf("Tip over the limit per transaction wasn't refused, got: %q.\n", got)
+	}
+	wrong[0] = '0' + (wrong[0]-'0'+1)%10
+
+	p = newFakePlatform(directMessage{alice, string(wrong), ""}, directMessage{alice, "yes", ""})
+	serve(p)
+	if got := p.waitSent(alice.ID, 1); len(got) != 1 || got[0] != "Wrong code. Transaction not sent." {
+		t.Errorf("Wrong code wasn't refused, got: %q.\n", got)
+	}
+
+	p = newFakePlatform(mention{"3", alice, bob, "@NebBot send 8 NAS", ""}, directMessage{alice, "yes", ""})
+	serve(p)
+	p = newFakePlatform(directMessage{alice, code(p, 2), ""})
+	serve(p)
+	want := []string{"Starting transaction...", active.explorerLink(), "Transaction confirmed. TX: limited-1"}
+	if got := p.waitSent(alice.ID, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs after the right code were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	p = newFakePlatform(
+		mention{"4", alice, bob, "@NebBot send 8 NAS", ""},
+		directMessage{alice, "limits daily 20", ""},
+		directMessage{alice, "limits code 1 NAS", ""},
+		mention{"5", alice, bob, "@NebBot send 2 NAS", ""},
+		directMessage{alice, "yes", ""},
+		directMessage{alice, "no", ""},
+		directMessage{alice, "limits reset", ""},
+	)
+	serve(p)
+	lower := `Lower them like "limits daily 10 NAS", or type "limits reset" to go back to the defaults.`
+	var own ownLimits
+	state.get(limitsBucket, alice.ID, &own)
+	want = []string{
+		"Sorry, 8 NAS is over your daily limit of 15 NAS, you've sent 8 NAS in the last 24 hours.",
+		"Sorry, that limit can be at most 15 NAS.",
+		"Your limits: 10 NAS per transaction, 15 NAS a day. Amounts over 1 NAS need a one-time code. You've sent 8 NAS in the last 24 hours.\n" + lower,
+		"CONFIRMATION: Send 2 NAS to @bob? (yes/NO)",
+		"Transaction not sent.",
+		fmt.Sprintf("Your limits: 10 NAS per transaction, 15 NAS a day. Amounts over 1 NAS need a one-time code. You've sent 8 NAS in the last 24 hours.\nThe limits you raised take effect on %v.\n", formatTime(own.RaisedFrom)) + lower,
+	}
+	got := p.waitSent(alice.ID, 7)
+	if len(got) != 7 || !strings.HasPrefix(got[4], "That's a large amount. To send 2 NAS") {
+		t.Fatalf("Code wasn't asked for below the lowered limit, got: %q.\n", got)
+	}
+	if got = append(got[:4], got[5:]...); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DMs about limits were incorrect, got: %q, want: %q.\n", got, want)
+	}
+
+	// Answering yes again doesn't give the code more time.
+	p = newFakePlatform(mention{"6", alice, bob, "@NebBot send 2 NAS", ""}, directMessage{alice, "yes", ""}, directMessage{alice, "yes", ""})
+	serve(p)
+	got = p.waitSent(alice.ID, 3)
+	if len(got) != 3 || got[2] != `To send 2 NAS, type the code I sent you, or "no" to cancel.` {
+		t.Errorf("Code wasn't asked for again, got: %q.\n", got)
+	}
+	state.deleteConfirmation(alice.ID)
+
+	if own.RaisedFrom.Sub(time.Now()) < limitRaiseDelay-time.Minute {
+		t.Errorf("Reset took effect too soon, on %v.\n", own.RaisedFrom)
+	}
+	own.RaisedFrom = time.Now()
+	state.put(limitsBucket, alice.ID, own)
+	if l, _ := userLimits(alice.ID); l.Code != "5000000000000000000" {
+		t.Errorf("Raised limits didn't take effect, got: %+v.\n", l)
+	}
+
+	// Two sends at once can't both fit under the daily limit.
+	carol := fmt.Sprint("fake:carol-", n)
+	getAcc(carol)
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- transfer(p, waiter{SenderID: carol, SenderName: "carol", Amount: "8000000000000000000"}, acc.addr.String())
+		}()
+	}
+	if err1, err2 := <-errs, <-errs; (err1 == nil) == (err2 == nil) {
+		t.Errorf("One of two sends over the daily limit should have been refused, got: %v, %v.\n", err1, err2)
+	}
+	p.waitSent(carol, 2)
+}
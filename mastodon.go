@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errorNotMastodonUser = errors.New("not a mastodon user ID")
+
+const mastodonPrefix = "mastodon:"
+
+// mastodon is the Platform adapter for a Mastodon server. Mentions and direct statuses
+// both arrive as notifications on the streaming API. User IDs are "mastodon:" + account ID.
+type mastodon struct {
+	server string
+	token  string
+
+	mu      sync.Mutex
+	botAcct string
+	// accts remembers the handle of every account seen, DMs are addressed by handle.
+	accts map[string]string
+}
+
+func newMastodon(getenv func(string) string) *mastodon {
+	return &mastodon{
+		server: strings.TrimSuffix(getenv("mastodonServer"), "/"),
+		token:  getenv("mastodonToken"),
+		accts:  map[string]string{},
+	}
+}
+
+type mastodonAccount struct {
+	ID   string `json:"id"`
+	Acct string `json:"acct"`
+	Bot  bool   `json:"bot"`
+}
+
+type mastodonStatus struct {
+	ID                 string            `json:"id"`
+	Content            string            `json:"content"`
+	Visibility         string            `json:"visibility"`
+	InReplyToID        string            `json:"in_reply_to_id"`
+	InReplyToAccountID string            `json:"in_reply_to_account_id"`
+	Mentions           []mastodonAccount `json:"mentions"`
+}
+
+// mastodonReply is a status in the descendants of a context, with the account that posted it.
+type mastodonReply struct {
+	InReplyToID string          `json:"in_reply_to_id"`
+	Account     mastodonAccount `json:"account"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type mastodonNotification struct {
+	Type    string          `json:"type"`
+	Account mastodonAccount `json:"account"`
+	Status  *mastodonStatus `json:"status"`
+}
+
+func (m *mastodon) Name() string {
+	return "mastodon"
+}
+
+// Listen reads the notification stream, reconnecting when it drops, until the token is rejected.
+func (m *mastodon) Listen(events chan<- interface{}) error {
+	var me mastodonAccount
+	err := m.do("GET", "/api/v1/accounts/verify_credentials", nil, &me)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.botAcct = me.Acct
+	m.mu.Unlock()
+
+	for {
+		err := m.stream(events)
+		if e, ok := err.(*httpError); ok && e.StatusCode == 401 {
+			return err
+		} else if err != nil {
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// stream forwards the notifications of one server-sent events connection until it ends.
+func (m *mastodon) stream(events chan<- interface{}) error {
+	req, err := http.NewRequest("GET", m.server+"/api/v1/streaming/user/notification", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return &httpError{req.URL.Path, resp.StatusCode, resp.Status}
+	}
+
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	event := ""
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[6:])
+		case strings.HasPrefix(line, "data:") && event == "notification":
+			n := mastodonNotification{}
+			if json.Unmarshal([]byte(strings.TrimSpace(line[5:])), &n) == nil {
+				m.handle(n, events)
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	return s.Err()
+}
+
+func (m *mastodon) handle(n mastodonNotification, events chan<- interface{}) {
+	if n.Type != "mention" || n.Status == nil || n.Account.Bot {
+		return
+	}
+
+	sender := m.remember(n.Account)
+	for _, a := range n.Status.Mentions {
+		m.remember(a)
+	}
+	text := m.plainText(n.Status.Content)
+
+	if n.Status.Visibility == "direct" {
+		events <- directMessage{sender, strings.TrimSpace(strings.Replace(text, "@NebBot", "", 1)), ""}
+		return
+	}
+
+	msg := mention{
+		StatusID: n.Status.ID,
+		Sender:   sender,
+		Text:     text,
+		ReplyTo:  n.Status.InReplyToID,
+	}
+	if id := n.Status.InReplyToAccountID; id != "" {
+		m.mu.Lock()
+		acct, ok := m.accts[id]
+		botAcct := m.botAcct
+		m.mu.Unlock()
+
+		if !ok {
+			var a mastodonAccount
+			err := m.do("GET", "/api/v1/accounts/"+url.PathEscape(id), nil, &a)
+			if err != nil {
+				fmt.Println(err)
+			}
+			acct = m.remember(a).Name
+		}
+		if acct != "" && acct != botAcct {
+			msg.Recipient = user{mastodonPrefix + id, acct}
+		}
+	}
+	events <- msg
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips the HTML of a status and writes the bot's handle as @NebBot like on Twitter.
+func (m *mastodon) plainText(content string) string {
+	content = strings.Replace(content, "<br>", "\n", -1)
+	content = strings.Replace(content, "<br />", "\n", -1)
+	content = strings.Replace(content, "</p><p>", "\n\n", -1)
+	text := html.UnescapeString(htmlTag.ReplaceAllString(content, ""))
+
+	m.mu.Lock()
+	botAcct := m.botAcct
+	m.mu.Unlock()
+
+	if botAcct != "" {
+		bot := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(botAcct) + `(@[\w.-]+)?\b`)
+		text = bot.ReplaceAllString(text, "@NebBot")
+	}
+	return strings.TrimSpace(text)
+}
+
+func (m *mastodon) remember(a mastodonAccount) user {
+	if a.ID == "" {
+		return user{}
+	}
+
+	m.mu.Lock()
+	m.accts[a.ID] = a.Acct
+	m.mu.Unlock()
+	return user{mastodonPrefix + a.ID, a.Acct}
+}
+
+// SendDM posts a direct status that only the bot and the user can see.
+func (m *mastodon) SendDM(userID string, text string) error {
+	if !strings.HasPrefix(userID, mastodonPrefix) {
+		return errorNotMastodonUser
+	}
+	id := userID[len(mastodonPrefix):]
+
+	m.mu.Lock()
+	acct, ok := m.accts[id]
+	m.mu.Unlock()
+
+	if !ok {
+		var a mastodonAccount
+		err := m.do("GET", "/api/v1/accounts/"+url.PathEscape(id), nil, &a)
+		if err != nil {
+			return err
+		}
+		acct = m.remember(a).Name
+	}
+
+	return m.do("POST", "/api/v1/statuses", map[string]string{
+		"status":     "@" + acct + " " + text,
+		"visibility": "direct",
+	}, nil)
+}
+
+func (m *mastodon) Reply(statusID string, text string) error {
+	return m.do("POST", "/api/v1/statuses", map[string]string{
+		"status":         text,
+		"in_reply_to_id": statusID,
+	}, nil)
+}
+
+func (m *mastodon) LookupUser(name string) (user, error) {
+	var a mastodonAccount
+	err := m.do("GET", "/api/v1/accounts/lookup?"+url.Values{"acct": {strings.TrimPrefix(name, "@")}}.Encode(), nil, &a)
+	if e, ok := err.(*httpError); ok && e.StatusCode == 404 {
+		return user{}, errorUnknownUser
+	} else if err != nil {
+		return user{}, err
+	}
+	return m.remember(a), nil
+}
+
+func (m *mastodon) Replies(statusID string) ([]reply, error) {
+	var c struct {
+		Descendants []mastodonReply `json:"descendants"`
+	}
+	err := m.do("GET", "/api/v1/statuses/"+url.PathEscape(statusID)+"/context", nil, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []reply
+	for _, r := range c.Descendants {
+		if r.InReplyToID == statusID && !r.Account.Bot {
+			replies = append(replies, reply{m.remember(r.Account), r.CreatedAt})
+		}
+	}
+	return replies, nil
+}
+
+func (m *mastodon) do(method, path string, in interface{}, out interface{}) error {
+	header := http.Header{"Authorization": {"Bearer " + m.token}}
+	return doJSON(method, m.server+path, header, in, out)
+}
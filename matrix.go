@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errorNotMatrixUser = errors.New("not a matrix user ID")
+
+const matrixPrefix = "matrix:"
+
+// matrix is the Platform adapter for the Matrix client-server API. Rooms with two members
+// are DMs, in any other room a message mentioning the bot is a mention. User IDs are "matrix:" + MXID.
+type matrix struct {
+	homeserver string
+	token      string
+
+	mu      sync.Mutex
+	botID   string
+	members map[string]int
+	// dms maps MXIDs to the direct room the bot talks to them in.
+	dms map[string]string
+	txn int64
+}
+
+func newMatrix(getenv func(string) string) *matrix {
+	return &matrix{
+		homeserver: strings.TrimSuffix(getenv("matrixHomeserver"), "/"),
+		token:      getenv("matrixToken"),
+		members:    map[string]int{},
+		dms:        map[string]string{},
+	}
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType   string `json:"msgtype"`
+		Body      string `json:"body"`
+		RelatesTo struct {
+			InReplyTo struct {
+				EventID string `json:"event_id"`
+			} `json:"m.in_reply_to"`
+		} `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Summary struct {
+				JoinedMembers *int `json:"m.joined_member_count"`
+			} `json:"summary"`
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]interface{} `json:"invite"`
+	} `json:"rooms"`
+}
+
+func (m *matrix) Name() string {
+	return "matrix"
+}
+
+// Listen runs the sync loop until the access token is rejected. Whatever happened
+// before the first sync is skipped so old messages aren't replayed on a restart.
+func (m *matrix) Listen(events chan<- interface{}) error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	err := m.do("GET", "/account/whoami", nil, &whoami)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.botID = whoami.UserID
+	m.mu.Unlock()
+
+	since := ""
+	for {
+		q := url.Values{"timeout": {"30000"}}
+		if since != "" {
+			q.Set("since", since)
+		} else {
+			q.Set("timeout", "0")
+		}
+
+		s := matrixSync{}
+		err := m.do("GET", "/sync?"+q.Encode(), nil, &s)
+		if e, ok := err.(*httpError); ok && e.StatusCode == 401 {
+			return err
+		} else if err != nil {
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		// Accept invites so users can open a DM with the bot.
+		for room := range s.Rooms.Invite {
+			err := m.do("POST", "/join/"+url.PathEscape(room), map[string]string{}, nil)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+
+		for room, r := range s.Rooms.Join {
+			if n := r.Summary.JoinedMembers; n != nil {
+				m.mu.Lock()
+				m.members[room] = *n
+				m.mu.Unlock()
+			}
+			if since == "" {
+				continue
+			}
+			for _, e := range r.Timeline.Events {
+				m.handle(room, e, events)
+			}
+		}
+		since = s.NextBatch
+	}
+}
+
+func (m *matrix) handle(room string, e matrixEvent, events chan<- interface{}) {
+	m.mu.Lock()
+	botID := m.botID
+	direct := m.members[room] == 2
+	if direct && e.Sender != botID {
+		m.dms[e.Sender] = room
+	}
+	m.mu.Unlock()
+
+	if e.Type != "m.room.message" || e.Content.MsgType != "m.text" || e.Sender == botID {
+		return
+	}
+
+	sender := user{matrixPrefix + e.Sender, strings.TrimPrefix(e.Sender, "@")}
+	body := stripReplyFallback(e.Content.Body)
+	if direct {
+		events <- directMessage{sender, body, ""}
+		return
+	}
+
+	// Users address the bot by its MXID or, like on Twitter, as @NebBot.
+	text := strings.Replace(body, botID, "@NebBot", -1)
+	if !strings.Contains(text, "@NebBot") {
+		return
+	}
+	msg := mention{
+		StatusID: room + "/" + e.EventID,
+		Sender:   sender,
+		Text:     text,
+	}
+
+	if reply := e.Content.RelatesTo.InReplyTo.EventID; reply != "" {
+		var original matrixEvent
+		err := m.do("GET", "/rooms/"+url.PathEscape(room)+"/event/"+url.PathEscape(reply), nil, &original)
+		if err != nil {
+			fmt.Println(err)
+		} else if original.Sender != botID {
+			msg.Recipient = user{matrixPrefix + original.Sender, strings.TrimPrefix(original.Sender, "@")}
+		}
+	}
+	events <- msg
+}
+
+// stripReplyFallback drops the "> <@user:server> quoted text" lines clients put in front of replies.
+func stripReplyFallback(body string) string {
+	if !strings.HasPrefix(body, "> ") {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	for i, l := range lines {
+		if !strings.HasPrefix(l, ">") {
+			return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+		}
+	}
+	return ""
+}
+
+func (m *matrix) SendDM(userID string, text string) error {
+	if !strings.HasPrefix(userID, matrixPrefix) {
+		return errorNotMatrixUser
+	}
+	mxid := userID[len(matrixPrefix):]
+
+	m.mu.Lock()
+	room, ok := m.dms[mxid]
+	m.mu.Unlock()
+
+	if !ok {
+		var r struct {
+			RoomID string `json:"room_id"`
+		}
+		err := m.do("POST", "/createRoom", map[string]interface{}{
+			"is_direct": true,
+			"invite":    []string{mxid},
+			"preset":    "trusted_private_chat",
+		}, &r)
+		if err != nil {
+			return err
+		}
+		room = r.RoomID
+
+		m.mu.Lock()
+		m.dms[mxid] = room
+		m.mu.Unlock()
+	}
+
+	return m.send(room, map[string]interface{}{"msgtype": "m.text", "body": text})
+}
+
+func (m *matrix) Reply(statusID string, text string) error {
+	ids := strings.SplitN(statusID, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("invalid matrix event ID %q", statusID)
+	}
+
+	return m.send(ids[0], map[string]interface{}{
+		"msgtype":      "m.text",
+		"body":         text,
+		"m.relates_to": map[string]interface{}{"m.in_reply_to": map[string]string{"event_id": ids[1]}},
+	})
+}
+
+func (m *matrix) send(room string, content interface{}) error {
+	m.mu.Lock()
+	m.txn++
+	txn := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatInt(m.txn, 10)
+	m.mu.Unlock()
+
+	return m.do("PUT", "/rooms/"+url.PathEscape(room)+"/send/m.room.message/"+txn, content, nil)
+}
+
+// LookupUser accepts full MXIDs, Matrix has no server independent usernames.
+func (m *matrix) LookupUser(name string) (user, error) {
+	mxid := "@" + strings.TrimPrefix(name, "@")
+	if !strings.Contains(mxid, ":") {
+		return user{}, errorUnknownUser
+	}
+
+	err := m.do("GET", "/profile/"+url.PathEscape(mxid), nil, nil)
+	if e, ok := err.(*httpError); ok && e.StatusCode == 404 {
+		return user{}, errorUnknownUser
+	} else if err != nil {
+		return user{}, err
+	}
+	return user{matrixPrefix + mxid, mxid[1:]}, nil
+}
+
+func (m *matrix) do(method, path string, in interface{}, out interface{}) error {
+	header := http.Header{"Authorization": {"Bearer " + m.token}}
+	return doJSON(method, m.homeserver+"/_matrix/client/v3"+path, header, in, out)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package cipher
+
+import (
+	"errors"
+
+	"../keystore"
+)
+
+var (
+	// ErrAlgorithmInvalid invalid Algorithm for encrypt.
+	ErrAlgorithmInvalid = errors.New("invalid Algorithm")
+)
+
+// Encrypt interface of a passphrase based key encryption
+type Encrypt interface {
+
+	// Encrypt encrypts data with passphrase and returns the encoded crypto section.
+	Encrypt(data []byte, passphrase []byte) ([]byte, error)
+
+	// Decrypt decrypts an encoded crypto section with passphrase.
+	Decrypt(data []byte, passphrase []byte) ([]byte, error)
+}
+
+// Cipher encrypts and decrypts keys with the configured algorithm
+type Cipher struct {
+	alg keystore.Algorithm
+
+	encrypt Encrypt
+}
+
+// NewCipher returns a cipher for alg
+func NewCipher(alg keystore.Algorithm) (*Cipher, error) {
+	c := new(Cipher)
+	c.alg = alg
+	switch alg {
+	case keystore.SCRYPT:
+		c.encrypt = new(Scrypt)
+	default:
+		return nil, ErrAlgorithmInvalid
+	}
+	return c, nil
+}
+
+// Encrypt encrypts data with passphrase
+func (c *Cipher) Encrypt(data []byte, passphrase []byte) ([]byte, error) {
+	return c.encrypt.Encrypt(data, passphrase)
+}
+
+// Decrypt decrypts data with passphrase
+func (c *Cipher) Decrypt(data []byte, passphrase []byte) ([]byte, error) {
+	return c.encrypt.Decrypt(data, passphrase)
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package cipher
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"../hash"
+	"../utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// ScryptKDF name
+	ScryptKDF = "scrypt"
+
+	// StandardScryptN N parameter of Scrypt encryption algorithm
+	StandardScryptN = 1 << 17
+
+	// StandardScryptR r parameter of Scrypt encryption algorithm
+	StandardScryptR = 8
+
+	// StandardScryptP p parameter of Scrypt encryption algorithm
+	StandardScryptP = 1
+
+	// ScryptDKLen get derived key length
+	ScryptDKLen = 32
+
+	// cipherName the name of cipher
+	cipherName = "aes-128-ctr"
+
+	// macHashSha3256 the mac hash written by nebulas version 4 key files
+	macHashSha3256 = "sha3256"
+)
+
+var (
+	// ErrVersionInvalid version not supported
+	ErrVersionInvalid = errors.New("version not supported")
+
+	// ErrKDFInvalid cipher not supported
+	ErrKDFInvalid = errors.New("kdf not supported")
+
+	// ErrCipherInvalid cipher not supported
+	ErrCipherInvalid = errors.New("cipher not supported")
+
+	// ErrDecrypt decrypt failed
+	ErrDecrypt = errors.New("could not decrypt key with given passphrase")
+)
+
+type cipherparamsJSON struct {
+	IV string `json:"iv"`
+}
+
+type scryptParamsJSON struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// CryptoJSON is the "crypto" section of a V3 key file
+type CryptoJSON struct {
+	Cipher       string           `json:"cipher"`
+	CipherText   string           `json:"ciphertext"`
+	CipherParams cipherparamsJSON `json:"cipherparams"`
+	KDF          string           `json:"kdf"`
+	KDFParams    scryptParamsJSON `json:"kdfparams"`
+	MAC          string           `json:"mac"`
+	MACHash      string           `json:"machash,omitempty"`
+}
+
+// Scrypt encrypts keys with an scrypt derived aes-128-ctr key and a keccak MAC.
+// Zero parameters fall back to the standard ones.
+type Scrypt struct {
+	N int
+	R int
+	P int
+}
+
+func (s *Scrypt) params() (int, int, int) {
+	n, r, p := s.N, s.R, s.P
+	if n == 0 {
+		n = StandardScryptN
+	}
+	if r == 0 {
+		r = StandardScryptR
+	}
+	if p == 0 {
+		p = StandardScryptP
+	}
+	return n, r, p
+}
+
+// Encrypt scrypt encrypt
+func (s *Scrypt) Encrypt(data []byte, passphrase []byte) ([]byte, error) {
+	crypto, err := s.EncryptJSON(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(crypto)
+}
+
+// EncryptJSON encrypts data and returns the crypto section of a V3 key file
+func (s *Scrypt) EncryptJSON(data []byte, passphrase []byte) (*CryptoJSON, error) {
+	n, r, p := s.params()
+	salt := utils.RandomCSPRNG(ScryptDKLen)
+	derivedKey, err := scrypt.Key(passphrase, salt, n, r, p, ScryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := utils.RandomCSPRNG(aes.BlockSize)
+	cipherText, err := aesCTRXOR(derivedKey[:16], data, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hash.Keccak256(derivedKey[16:32], cipherText)
+
+	return &CryptoJSON{
+		Cipher:       cipherName,
+		CipherText:   hex.EncodeToString(cipherText),
+		CipherParams: cipherparamsJSON{hex.EncodeToString(iv)},
+		KDF:          ScryptKDF,
+		KDFParams:    scryptParamsJSON{n, r, p, ScryptDKLen, hex.EncodeToString(salt)},
+		MAC:          hex.EncodeToString(mac),
+	}, nil
+}
+
+// Decrypt scrypt decrypt
+func (s *Scrypt) Decrypt(data []byte, passphrase []byte) ([]byte, error) {
+	crypto := new(CryptoJSON)
+	if err := json.Unmarshal(data, crypto); err != nil {
+		return nil, err
+	}
+	return s.DecryptJSON(crypto, passphrase)
+}
+
+// DecryptJSON decrypts the crypto section of a V3 key file. Sections written by
+// nebulas version 4 key files, with "machash":"sha3256", are accepted as well.
+func (s *Scrypt) DecryptJSON(crypto *CryptoJSON, passphrase []byte) ([]byte, error) {
+	if crypto.Cipher != cipherName {
+		return nil, ErrCipherInvalid
+	}
+	if crypto.KDF != ScryptKDF {
+		return nil, ErrKDFInvalid
+	}
+
+	mac, err := hex.DecodeString(crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(crypto.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(crypto.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	kp := crypto.KDFParams
+	if kp.DKLen != ScryptDKLen {
+		return nil, ErrKDFInvalid
+	}
+	derivedKey, err := scrypt.Key(passphrase, salt, kp.N, kp.R, kp.P, kp.DKLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var calculatedMAC []byte
+	switch crypto.MACHash {
+	case "":
+		calculatedMAC = hash.Keccak256(derivedKey[16:32], cipherText)
+	case macHashSha3256:
+		calculatedMAC = hash.Sha3256(derivedKey[16:32], cipherText, iv, []byte(crypto.Cipher))
+	default:
+		return nil, ErrVersionInvalid
+	}
+	if !bytes.Equal(calculatedMAC, mac) {
+		return nil, ErrDecrypt
+	}
+
+	return aesCTRXOR(derivedKey[:16], cipherText, iv)
+}
+
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, nil
+}
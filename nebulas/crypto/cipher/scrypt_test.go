@@ -0,0 +1,71 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package cipher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// Test vector from the Web3 Secret Storage Definition.
+const v3TestVector = `{"cipher":"aes-128-ctr","cipherparams":{"iv":"83dbcc02d8ccb40e466191a123791e0e"},"ciphertext":"d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c","kdf":"scrypt","kdfparams":{"dklen":32,"n":262144,"p":8,"r":1,"salt":"ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"},"mac":"2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3097"}`
+
+func TestScryptDecryptV3(t *testing.T) {
+	s := new(Scrypt)
+	key, err := s.Decrypt([]byte(v3TestVector), []byte("testpassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+	if hex.EncodeToString(key) != want {
+		t.Errorf("decrypted key = %x, want %s", key, want)
+	}
+
+	if _, err := s.Decrypt([]byte(v3TestVector), []byte("wrong")); err != ErrDecrypt {
+		t.Errorf("wrong passphrase err = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestScryptRoundTrip(t *testing.T) {
+	s := &Scrypt{N: 1 << 10}
+	data := []byte("0123456789abcdef0123456789abcdef")
+
+	encrypted, err := s.Encrypt(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crypto := new(CryptoJSON)
+	if err := json.Unmarshal(encrypted, crypto); err != nil {
+		t.Fatal(err)
+	}
+	if crypto.KDFParams.N != 1<<10 || crypto.KDFParams.R != StandardScryptR {
+		t.Errorf("kdfparams = %+v", crypto.KDFParams)
+	}
+
+	decrypted, err := new(Scrypt).Decrypt(encrypted, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("decrypted = %x, want %x", decrypted, data)
+	}
+}
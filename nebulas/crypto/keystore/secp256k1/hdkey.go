@@ -0,0 +1,132 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package secp256k1
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// HardenedKeyStart is the index of the first hardened BIP32 child key.
+const HardenedKeyStart uint32 = 1 << 31
+
+// NebulasCoinType is the SLIP-44 coin type registered for Nebulas.
+const NebulasCoinType uint32 = 2718
+
+var (
+	// ErrInvalidSeedLen seed length must be between 128 and 512 bits
+	ErrInvalidSeedLen = errors.New("invalid seed length")
+
+	// ErrInvalidChild derived key is invalid, the next index should be used
+	ErrInvalidChild = errors.New("invalid child key")
+)
+
+var masterKeySeed = []byte("Bitcoin seed")
+
+// ExtendedKey is a BIP32 extended private key
+type ExtendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// NewMasterKey derives the BIP32 master key from a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeedLen
+	}
+
+	mac := hmac.New(sha512.New, masterKeySeed)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	if !SeckeyVerify(sum[:32]) {
+		return nil, ErrInvalidChild
+	}
+	return &ExtendedKey{sum[:32], sum[32:]}, nil
+}
+
+// Child derives the child key at index i, hardened when i >= HardenedKeyStart
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	var data []byte
+	if i >= HardenedKeyStart {
+		data = append([]byte{0}, k.key...)
+	} else {
+		pub, err := GetPublicKey(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = compressPublicKey(pub)
+	}
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, i)
+	data = append(data, index...)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := il.Add(il, new(big.Int).SetBytes(k.key))
+	child.Mod(child, n)
+	if child.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+
+	return &ExtendedKey{paddedBigBytes(child, EcdsaPrivateKeyLength), sum[32:]}, nil
+}
+
+// Derive follows path from k, skipping to the next index whenever a child is invalid
+func (k *ExtendedKey) Derive(path ...uint32) (*ExtendedKey, error) {
+	var err error
+	key := k
+	for _, i := range path {
+		var child *ExtendedKey
+		for child, err = key.Child(i); err == ErrInvalidChild; child, err = key.Child(i) {
+			i++
+		}
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
+
+// PrivateKey returns the secp256k1 private key of k
+func (k *ExtendedKey) PrivateKey() *PrivateKey {
+	seckey := make([]byte, len(k.key))
+	copy(seckey, k.key)
+	return &PrivateKey{seckey}
+}
+
+// compressPublicKey turns a 65 byte uncompressed public key into its 33 byte form
+func compressPublicKey(pub []byte) []byte {
+	out := make([]byte, 33)
+	out[0] = 2 + pub[64]&1
+	copy(out[1:], pub[1:33])
+	return out
+}
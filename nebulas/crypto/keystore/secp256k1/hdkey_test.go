@@ -0,0 +1,53 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package secp256k1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Test vector 1 from BIP32.
+func TestExtendedKeyDerive(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path []uint32
+		key  string
+	}{
+		{nil, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{[]uint32{HardenedKeyStart}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{[]uint32{HardenedKeyStart, 1, HardenedKeyStart + 2, 2, 1000000000}, "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+	}
+
+	for _, tt := range tests {
+		k, err := master.Derive(tt.path...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := k.PrivateKey().Encoded()
+		if hex.EncodeToString(got) != tt.key {
+			t.Errorf("Derive(%v) = %x, want %s", tt.path, got, tt.key)
+		}
+	}
+}
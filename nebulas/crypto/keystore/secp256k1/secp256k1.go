@@ -16,6 +16,9 @@
 // along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
 //
 
+//go:build cgo && !purego
+// +build cgo,!purego
+
 package secp256k1
 
 /*
@@ -37,38 +40,7 @@ import "C"
 //#cgo CFLAGS: -Wno-error
 
 import (
-	"errors"
 	"unsafe"
-
-	"../../utils"
-)
-
-const (
-	// EcdsaPrivateKeyLength private key length
-	EcdsaPrivateKeyLength = 32
-)
-
-var (
-	// ErrInvalidMsgLen invalid message length
-	ErrInvalidMsgLen = errors.New("invalid message length, need 32 bytes")
-
-	// ErrGetPublicKeyFailed private key to public failed
-	ErrGetPublicKeyFailed = errors.New("private key to public failed")
-
-	// ErrInvalidSignature invalid signature length
-	ErrInvalidSignature = errors.New("invalid signature")
-
-	// ErrInvalidPrivateKey invalid private key
-	ErrInvalidPrivateKey = errors.New("invalid private key")
-
-	// ErrInvalidPublicKey invalid public key
-	ErrInvalidPublicKey = errors.New("invalid public key")
-
-	// ErrSignFailed sign failed
-	ErrSignFailed = errors.New("sign failed")
-
-	// ErrRecoverFailed recover failed
-	ErrRecoverFailed = errors.New("recovery failed")
 )
 
 var ctx *C.secp256k1_context
@@ -80,21 +52,6 @@ func init() {
 	ctx = C.secp256k1_context_create(C.SECP256K1_CONTEXT_SIGN | C.SECP256K1_CONTEXT_VERIFY)
 }
 
-// NewSeckey generate a ecdsa private key by secp256k1
-func NewSeckey() []byte {
-	var priv []byte
-
-	// in bitcoin src, they call SeckeyVerify func to verify the generated private key
-	// to make sure valid.
-	for {
-		priv = utils.RandomCSPRNG(EcdsaPrivateKeyLength)
-		if SeckeyVerify(priv) {
-			break
-		}
-	}
-	return priv
-}
-
 // SeckeyVerify check private is ok for secp256k1
 func SeckeyVerify(seckey []byte) bool {
 	return C.secp256k1_ec_seckey_verify(ctx, cBuf(seckey)) == 1
@@ -0,0 +1,294 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+package secp256k1
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+
+	"./bitelliptic"
+)
+
+// Pure Go versions of the libsecp256k1 functions. They produce the same keys,
+// signatures (RFC6979 nonces, low S, recovery id) and recovered public keys, and
+// are used instead of the cgo ones when building with -tags purego or without cgo.
+
+var (
+	curve     = bitelliptic.S256()
+	curveN    = curve.N
+	curveHalf = new(big.Int).Rsh(curve.N, 1)
+)
+
+func goSeckeyVerify(seckey []byte) bool {
+	if len(seckey) != EcdsaPrivateKeyLength {
+		return false
+	}
+	d := new(big.Int).SetBytes(seckey)
+	return d.Sign() > 0 && d.Cmp(curveN) < 0
+}
+
+func goGetPublicKey(seckey []byte) ([]byte, error) {
+	if !goSeckeyVerify(seckey) {
+		return nil, ErrGetPublicKeyFailed
+	}
+	x, y := curve.ScalarBaseMult(seckey)
+	return curve.Marshal(x, y), nil
+}
+
+func goSign(msg []byte, seckey []byte) ([]byte, error) {
+	if len(msg) != 32 {
+		return nil, ErrInvalidMsgLen
+	}
+	if !goSeckeyVerify(seckey) {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	d := new(big.Int).SetBytes(seckey)
+	m := new(big.Int).SetBytes(msg)
+	m.Mod(m, curveN)
+
+	rng := newRFC6979(append(append([]byte{}, seckey...), msg...))
+	for {
+		nonce := rng.generate()
+		k := new(big.Int).SetBytes(nonce)
+		if k.Sign() == 0 || k.Cmp(curveN) >= 0 {
+			continue
+		}
+
+		rx, ry := curve.ScalarBaseMult(nonce)
+		r := new(big.Int).Mod(rx, curveN)
+		if r.Sign() == 0 {
+			continue
+		}
+		recid := byte(ry.Bit(0))
+		if rx.Cmp(curveN) >= 0 {
+			recid |= 2
+		}
+
+		s := new(big.Int).Mul(r, d)
+		s.Add(s, m)
+		s.Mul(s, new(big.Int).ModInverse(k, curveN))
+		s.Mod(s, curveN)
+		if s.Sign() == 0 {
+			continue
+		}
+		if s.Cmp(curveHalf) > 0 {
+			s.Sub(curveN, s)
+			recid ^= 1
+		}
+
+		sig := make([]byte, 65)
+		copy(sig[32-len(r.Bytes()):32], r.Bytes())
+		copy(sig[64-len(s.Bytes()):64], s.Bytes())
+		sig[64] = recid
+		return sig, nil
+	}
+}
+
+func goVerify(msg []byte, signature []byte, pub []byte) (bool, error) {
+	if len(msg) != 32 {
+		return false, ErrInvalidMsgLen
+	}
+
+	qx, qy := parsePublicKey(pub)
+	if qx == nil {
+		return false, ErrInvalidPublicKey
+	}
+
+	if len(signature) < 64 {
+		return false, ErrInvalidSignature
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	if r.Cmp(curveN) >= 0 || s.Cmp(curveN) >= 0 {
+		return false, ErrInvalidSignature
+	}
+
+	// libsecp256k1 only accepts low S signatures.
+	if r.Sign() == 0 || s.Sign() == 0 || s.Cmp(curveHalf) > 0 {
+		return false, nil
+	}
+
+	m := new(big.Int).SetBytes(msg)
+	w := new(big.Int).ModInverse(s, curveN)
+	u1 := m.Mul(m, w)
+	u1.Mod(u1, curveN)
+	u2 := w.Mul(w, r)
+	u2.Mod(u2, curveN)
+
+	x1, y1 := curve.ScalarBaseMult(u1.Bytes())
+	x2, y2 := curve.ScalarMult(qx, qy, u2.Bytes())
+	x, _ := addPoints(x1, y1, x2, y2)
+	if x == nil {
+		return false, nil
+	}
+	return x.Mod(x, curveN).Cmp(r) == 0, nil
+}
+
+func goRecoverECDSAPublicKey(msg []byte, signature []byte) ([]byte, error) {
+	if len(msg) != 32 {
+		return nil, ErrInvalidMsgLen
+	}
+	if len(signature) != 65 {
+		return nil, ErrInvalidSignature
+	}
+	recid := signature[64]
+	if recid > 3 {
+		return nil, ErrRecoverFailed
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	if r.Cmp(curveN) >= 0 || s.Cmp(curveN) >= 0 || r.Sign() == 0 || s.Sign() == 0 {
+		return nil, ErrRecoverFailed
+	}
+
+	rx := new(big.Int).Set(r)
+	if recid&2 != 0 {
+		rx.Add(rx, curveN)
+		if rx.Cmp(curve.P) >= 0 {
+			return nil, ErrRecoverFailed
+		}
+	}
+	ry := decompressY(rx, recid&1 == 1)
+	if ry == nil {
+		return nil, ErrRecoverFailed
+	}
+
+	// Q = r^-1 (s*R - m*G)
+	rinv := new(big.Int).ModInverse(r, curveN)
+	m := new(big.Int).SetBytes(msg)
+	u1 := m.Neg(m)
+	u1.Mul(u1, rinv)
+	u1.Mod(u1, curveN)
+	u2 := new(big.Int).Mul(s, rinv)
+	u2.Mod(u2, curveN)
+
+	x1, y1 := curve.ScalarBaseMult(u1.Bytes())
+	x2, y2 := curve.ScalarMult(rx, ry, u2.Bytes())
+	qx, qy := addPoints(x1, y1, x2, y2)
+	if qx == nil {
+		return nil, ErrRecoverFailed
+	}
+	return curve.Marshal(qx, qy), nil
+}
+
+// addPoints adds two affine points, nil standing for the point at infinity.
+func addPoints(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	switch {
+	case x1 == nil:
+		return x2, y2
+	case x2 == nil:
+		return x1, y1
+	case x1.Cmp(x2) == 0 && y1.Cmp(y2) == 0:
+		return curve.Double(x1, y1)
+	case x1.Cmp(x2) == 0:
+		return nil, nil
+	}
+	return curve.Add(x1, y1, x2, y2)
+}
+
+// decompressY returns the y coordinate of x with the requested parity, or nil if
+// x is not on the curve.
+func decompressY(x *big.Int, odd bool) *big.Int {
+	// y² = x³ + 7, and p ≡ 3 mod 4 so y = (y²)^((p+1)/4)
+	y2 := new(big.Int).Exp(x, big.NewInt(3), curve.P)
+	y2.Add(y2, curve.B)
+	y2.Mod(y2, curve.P)
+
+	exp := new(big.Int).Add(curve.P, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, curve.P)
+	if new(big.Int).Exp(y, big.NewInt(2), curve.P).Cmp(y2) != 0 {
+		return nil
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(curve.P, y)
+	}
+	return y
+}
+
+// parsePublicKey accepts the same encodings as secp256k1_ec_pubkey_parse:
+// compressed, uncompressed and hybrid.
+func parsePublicKey(pub []byte) (*big.Int, *big.Int) {
+	switch {
+	case len(pub) == 33 && (pub[0] == 2 || pub[0] == 3):
+		x := new(big.Int).SetBytes(pub[1:])
+		if x.Cmp(curve.P) >= 0 {
+			return nil, nil
+		}
+		y := decompressY(x, pub[0] == 3)
+		if y == nil {
+			return nil, nil
+		}
+		return x, y
+	case len(pub) == 65 && (pub[0] == 4 || pub[0] == 6 || pub[0] == 7):
+		x := new(big.Int).SetBytes(pub[1:33])
+		y := new(big.Int).SetBytes(pub[33:])
+		if x.Cmp(curve.P) >= 0 || y.Cmp(curve.P) >= 0 || !curve.IsOnCurve(x, y) {
+			return nil, nil
+		}
+		if pub[0] != 4 && (y.Bit(0) == 1) != (pub[0] == 7) {
+			return nil, nil
+		}
+		return x, y
+	}
+	return nil, nil
+}
+
+// rfc6979 is the HMAC-SHA256 nonce generator of RFC6979 section 3.2, fed the same
+// way libsecp256k1's secp256k1_nonce_function_rfc6979 feeds it.
+type rfc6979 struct {
+	k, v  []byte
+	retry bool
+}
+
+func newRFC6979(key []byte) *rfc6979 {
+	g := &rfc6979{k: make([]byte, 32), v: make([]byte, 32)}
+	for i := range g.v {
+		g.v[i] = 1
+	}
+	g.k = g.mac(g.k, g.v, []byte{0}, key)
+	g.v = g.mac(g.k, g.v)
+	g.k = g.mac(g.k, g.v, []byte{1}, key)
+	g.v = g.mac(g.k, g.v)
+	return g
+}
+
+func (g *rfc6979) generate() []byte {
+	if g.retry {
+		g.k = g.mac(g.k, g.v, []byte{0})
+		g.v = g.mac(g.k, g.v)
+	}
+	g.v = g.mac(g.k, g.v)
+	g.retry = true
+
+	out := make([]byte, 32)
+	copy(out, g.v)
+	return out
+}
+
+func (g *rfc6979) mac(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+//go:build !cgo || purego
+// +build !cgo purego
+
+package secp256k1
+
+// SeckeyVerify check private is ok for secp256k1
+func SeckeyVerify(seckey []byte) bool {
+	return goSeckeyVerify(seckey)
+}
+
+// GetPublicKey private key to public key
+func GetPublicKey(seckey []byte) ([]byte, error) {
+	return goGetPublicKey(seckey)
+}
+
+// RecoverECDSAPublicKey recover verifies the compact signature "signature" of "hash"
+func RecoverECDSAPublicKey(msg []byte, signature []byte) ([]byte, error) {
+	return goRecoverECDSAPublicKey(msg, signature)
+}
+
+// Sign sign hash with private key
+func Sign(msg []byte, seckey []byte) ([]byte, error) {
+	return goSign(msg, seckey)
+}
+
+// Verify verify with public key
+func Verify(msg []byte, signature []byte, pub []byte) (bool, error) {
+	return goVerify(msg, signature, pub)
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2017 go-nebulas authors
+//
+// This file is part of the go-nebulas library.
+//
+// the go-nebulas library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// the go-nebulas library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with the go-nebulas library.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package secp256k1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"../../utils"
+)
+
+// The pure Go functions must agree with libsecp256k1 byte for byte.
+
+func TestPureGoGetPublicKey(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		seckey := NewSeckey()
+		want, err := GetPublicKey(seckey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := goGetPublicKey(seckey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("goGetPublicKey(%x) = %x, want %x", seckey, got, want)
+		}
+	}
+
+	invalid := [][]byte{make([]byte, 32), bytes.Repeat([]byte{0xff}, 32)}
+	for _, seckey := range invalid {
+		if SeckeyVerify(seckey) != goSeckeyVerify(seckey) {
+			t.Errorf("SeckeyVerify(%x) disagrees", seckey)
+		}
+		if _, err := goGetPublicKey(seckey); err == nil {
+			t.Errorf("goGetPublicKey(%x) accepted an invalid key", seckey)
+		}
+	}
+}
+
+func TestPureGoSign(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		seckey := NewSeckey()
+		msg := utils.RandomCSPRNG(32)
+
+		want, err := Sign(msg, seckey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := goSign(msg, seckey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("goSign(%x, %x) = %x, want %x", msg, seckey, got, want)
+		}
+	}
+}
+
+func TestPureGoVerifyAndRecover(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		seckey := NewSeckey()
+		pub, _ := GetPublicKey(seckey)
+		idx := make([]byte, 8)
+		binary.BigEndian.PutUint64(idx, uint64(i))
+		sum := sha256.Sum256(idx)
+		msg := sum[:]
+		sig, _ := Sign(msg, seckey)
+
+		recovered, err := goRecoverECDSAPublicKey(msg, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := RecoverECDSAPublicKey(msg, sig)
+		if !bytes.Equal(recovered, want) || !bytes.Equal(recovered, pub) {
+			t.Fatalf("goRecoverECDSAPublicKey = %x, want %x", recovered, want)
+		}
+
+		compressed := compressPublicKey(pub)
+		tampered := append([]byte{}, sig...)
+		tampered[10] ^= 1
+		high := append([]byte{}, sig...)
+		s := new(big.Int).SetBytes(high[32:64])
+		copy(high[32:64], paddedBigBytes(s.Sub(curveN, s), 32))
+
+		for _, c := range []struct {
+			sig []byte
+			pub []byte
+		}{{sig, pub}, {sig, compressed}, {tampered, pub}, {high, pub}, {sig[:64], pub}} {
+			want, wantErr := Verify(msg, c.sig, c.pub)
+			got, gotErr := goVerify(msg, c.sig, c.pub)
+			if got != want || (gotErr == nil) != (wantErr == nil) {
+				t.Fatalf("goVerify(%x, %x) = %v, %v, want %v, %v", c.sig, c.pub, got, gotErr, want, wantErr)
+			}
+		}
+	}
+}
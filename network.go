@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// network describes the node, chain and contract the bot talks to.
+type network struct {
+	name     string
+	url      string
+	chainID  uint32
+	contract string
+	explorer string
+}
+
+var networks = map[string]network{
+	"mainnet": {"mainnet", "https://mainnet.nebulas.io", 1, "n1euKcZAkpvAhLegcryk5qFFuV3v7GzFHNG", "https://explorer.nebulas.io/#/"},
+	"testnet": {"testnet", "https://testnet.nebulas.io", 1001, "", "https://explorer.nebulas.io/#/testnet/"},
+	"local":   {"local", "http://localhost:8685", 100, "", ""},
+}
+
+var active, errorNetwork = loadNetwork(os.Getenv)
+
+// loadNetwork picks the profile named by the "network" env var (mainnet by default)
+// and applies any "nodeURL", "chainID", "contractAddress" and "explorerURL" overrides.
+func loadNetwork(getenv func(string) string) (network, error) {
+	name := getenv("network")
+	if name == "" {
+		name = "mainnet"
+	}
+
+	n, ok := networks[name]
+	if !ok {
+		return network{}, fmt.Errorf("unknown network %q", name)
+	}
+
+	if v := getenv("nodeURL"); v != "" {
+		n.url = v
+	}
+
+	if v := getenv("chainID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return network{}, fmt.Errorf("invalid chainID %q", v)
+		}
+		n.chainID = uint32(id)
+	}
+
+	if v := getenv("contractAddress"); v != "" {
+		n.contract = v
+	}
+
+	if v := getenv("explorerURL"); v != "" {
+		n.explorer = v
+	}
+
+	if n.contract == "" {
+		return network{}, fmt.Errorf("no contract address configured for %v", n.name)
+	}
+
+	return n, nil
+}
+
+// explorerLink returns the sentence pointing users at their pending transactions.
+func (n network) explorerLink() string {
+	if n.explorer == "" {
+		return "Transaction sent."
+	}
+	return fmt.Sprintf("Transaction sent. View your pending transactions at %v", n.explorer)
+}
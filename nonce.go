@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"./nebulas"
+)
+
+// nonceManager hands out transaction nonces per address. The node only knows about
+// nonces that made it into a block, so the ones we have already used are tracked here.
+type nonceManager struct {
+	mu       sync.Mutex
+	node     *rpcClient
+	accounts map[string]*accountNonce
+}
+
+type accountNonce struct {
+	mu     sync.Mutex
+	synced bool
+	last   uint64
+	// free are the nonces below last that were given back, handed out again first so
+	// they don't leave a gap.
+	free []uint64
+}
+
+var nonces = newNonceManager(node)
+
+func newNonceManager(node *rpcClient) *nonceManager {
+	return &nonceManager{node: node, accounts: map[string]*accountNonce{}}
+}
+
+func (m *nonceManager) account(addr *core.Address) *accountNonce {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.accounts[addr.String()]
+	if !ok {
+		a = &accountNonce{}
+		m.accounts[addr.String()] = a
+	}
+	return a
+}
+
+// next reserves the next nonce for addr. Every reserved nonce must either be submitted
+// or given back with release, otherwise later transactions stay stuck behind the gap.
+func (m *nonceManager) next(addr *core.Address) (uint64, error) {
+	a := m.account(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.sync(m.node, addr)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(a.free) > 0 {
+		n := a.free[0]
+		a.free = a.free[1:]
+		return n, nil
+	}
+	a.last++
+	return a.last, nil
+}
+
+// peek returns the nonce next would hand out, without reserving it. Fees are estimated
+// with it ahead of sending.
+func (m *nonceManager) peek(addr *core.Address) (uint64, error) {
+	a := m.account(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.sync(m.node, addr)
+	if err != nil {
+		return 0, err
+	}
+	if len(a.free) > 0 {
+		return a.free[0], nil
+	}
+	return a.last + 1, nil
+}
+
+// sync asks the node for the nonce of addr unless a is in sync already.
+func (a *accountNonce) sync(node *rpcClient, addr *core.Address) error {
+	if a.synced {
+		return nil
+	}
+	state, err := node.accountState(addr)
+	if err != nil {
+		return err
+	}
+	// The node's nonce only counts mined transactions, ours may still be in its pool.
+	if state.Nonce > a.last {
+		a.last = state.Nonce
+	}
+	for len(a.free) > 0 && a.free[0] <= state.Nonce {
+		a.free = a.free[1:]
+	}
+	a.synced = true
+	return nil
+}
+
+// release gives back a nonce that was never accepted by the node.
+func (m *nonceManager) release(addr *core.Address, nonce uint64) {
+	a := m.account(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	i := sort.Search(len(a.free), func(i int) bool { return a.free[i] >= nonce })
+	if i < len(a.free) && a.free[i] == nonce || nonce > a.last {
+		return
+	}
+	a.free = append(a.free, 0)
+	copy(a.free[i+1:], a.free[i:])
+	a.free[i] = nonce
+
+	// Given back from the top, there's no gap.
+	for len(a.free) > 0 && a.free[len(a.free)-1] == a.last {
+		a.free = a.free[:len(a.free)-1]
+		a.last--
+	}
+}
+
+// resync makes the next allocation ask the node where addr is. What the node says
+// only raises the nonces we hand out, it doesn't know about the pending ones.
+func (m *nonceManager) resync(addr *core.Address) {
+	a := m.account(addr)
+	a.mu.Lock()
+	a.synced = false
+	a.mu.Unlock()
+}
+
+// isNonceError reports whether the node rejected a transaction because of its nonce.
+func isNonceError(err error) bool {
+	e, ok := err.(*rpcError)
+	if !ok {
+		return false
+	}
+
+	// The node words this differently depending on where the tx was rejected, e.g.
+	// core.ErrSmallTransactionNonce or "transaction's nonce is invalid".
+	return strings.Contains(strings.ToLower(e.Message), "nonce")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var errorUnknownUser = errors.New("unknown user")
+var errorRepliesTruncated = errors.New("some replies may be missing")
+
+// Platform is a chat network the bot listens on, e.g. Twitter.
+//
+// User IDs are opaque strings owned by the adapter and double as key store IDs,
+// so every adapter must keep its IDs in a namespace no other adapter can produce.
+type Platform interface {
+	Name() string
+	// Listen blocks and sends every mention and directMessage addressed to the bot to events.
+	Listen(events chan<- interface{}) error
+	SendDM(userID string, text string) error
+	// Reply publicly answers the mention with the given StatusID.
+	Reply(statusID string, text string) error
+	LookupUser(name string) (user, error)
+}
+
+// confirmer is implemented by platforms that ask for confirmations with buttons.
+// The answer must still arrive as a "yes" or "no" directMessage, with Answers set
+// to the confirmationID it was sent with.
+type confirmer interface {
+	SendConfirmation(userID string, text string, confirmationID string) error
+}
+
+// replyLister is implemented by platforms that can list who replied to a post, for airdrops.
+type replyLister interface {
+	// Replies returns the direct replies to the post with the given ID. When it may have
+	// missed some, it returns the ones it found with errorRepliesTruncated.
+	Replies(statusID string) ([]reply, error)
+}
+
+// reply is a direct reply to a post, as listed by a replyLister.
+type reply struct {
+	Author user
+	Posted time.Time
+}
+
+type user struct {
+	ID   string
+	Name string
+}
+
+// mention is a public message addressed to the bot. Recipient is the author of the
+// message it replies to and has an empty ID when it isn't a reply.
+type mention struct {
+	StatusID  string
+	Sender    user
+	Recipient user
+	Text      string
+	// ReplyTo is the ID of the post the mention replies to, set by platforms that are replyListers.
+	ReplyTo string
+}
+
+type directMessage struct {
+	Sender user
+	Text   string
+	// Answers is the ID of the confirmation a button answers, empty for typed messages.
+	Answers string
+}
+
+// loadPlatforms creates the adapters listed in the comma separated "platforms" env var, twitter by default.
+func loadPlatforms(getenv func(string) string) ([]Platform, error) {
+	names := getenv("platforms")
+	if names == "" {
+		names = "twitter"
+	}
+
+	var ps []Platform
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "twitter":
+			ps = append(ps, newTwitter(getenv))
+		case "discord":
+			ps = append(ps, newDiscord(getenv))
+		case "telegram":
+			ps = append(ps, newTelegram(getenv))
+		case "matrix":
+			ps = append(ps, newMatrix(getenv))
+		case "mastodon":
+			ps = append(ps, newMastodon(getenv))
+		case "slack":
+			if getenv("slackSigningSecret") == "" {
+				return nil, errorSlackSecret
+			}
+			ps = append(ps, newSlack(getenv))
+		default:
+			return nil, fmt.Errorf("unknown platform %q", name)
+		}
+	}
+	return ps, nil
+}
+
+// dm sends text to userID and logs failures, the bot has nowhere else to report them.
+func dm(p Platform, userID string, text string) {
+	err := p.SendDM(userID, text)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"./nebulas"
+	"./nebulas/util"
+)
+
+var errorAmountTooSmall = errors.New("amount is less than 1 wei per recipient")
+
+// rainMaxRecipients caps how many transfers a single rain sends.
+const rainMaxRecipients = 50
+
+// splitAmount divides total between n recipients exactly. Every recipient gets total/n
+// rounded down to the wei, and the remainder, less than n wei, goes one wei each to the
+// first recipients in order, so the shares always add up to total.
+func splitAmount(total *util.Uint128, n int) ([]*util.Uint128, error) {
+	count := uint128(uint64(n))
+	share, err := total.Div(count)
+	if err != nil {
+		return nil, err
+	}
+	if share.Cmp(util.NewUint128()) == 0 {
+		return nil, errorAmountTooSmall
+	}
+
+	split, err := share.Mul(count)
+	if err != nil {
+		return nil, err
+	}
+	remainder, err := total.Sub(split)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*util.Uint128, n)
+	for i := range shares {
+		shares[i] = share
+		if uint64(i) < remainder.Uint64() {
+			shares[i], err = share.Add(uint128(1))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return shares, nil
+}
+
+// rain resolves who the rain cmd of m goes to and asks the sender to confirm the split.
+func rain(p Platform, m mention, cmd rainCmd) {
+	var candidates []user
+	var unknown []string
+	var truncated bool
+	switch {
+	case len(cmd.Recipients) > 0:
+		for _, name := range cmd.Recipients {
+			u, err := p.LookupUser(name)
+			if err != nil {
+				fmt.Println(err)
+				unknown = append(unknown, "@"+name)
+				continue
+			}
+			candidates = append(candidates, u)
+		}
+	case m.ReplyTo == "":
+		dm(p, m.Sender.ID, `To make it rain, name the recipients like "@NebBot rain 10 NAS on @alice @bob", or reply to a post to share it among everyone who replied to that post.`)
+		return
+	default:
+		l, ok := p.(replyLister)
+		if !ok {
+			dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I can't list replies on %v, name the recipients instead.", p.Name()))
+			return
+		}
+		replies, err := l.Replies(m.ReplyTo)
+		truncated = err == errorRepliesTruncated
+		if err != nil && !truncated {
+			fmt.Println(err)
+			dm(p, m.Sender.ID, "Sorry, something went wrong.")
+			return
+		}
+		for _, r := range replies {
+			candidates = append(candidates, r.Author)
+		}
+	}
+
+	// Everyone gets one share, however often they were named or replied.
+	var recipients []user
+	seen := map[string]bool{m.Sender.ID: true}
+	for _, u := range candidates {
+		if u.ID != "" && !seen[u.ID] {
+			seen[u.ID] = true
+			recipients = append(recipients, u)
+		}
+	}
+
+	if len(recipients) == 0 {
+		dm(p, m.Sender.ID, "There's nobody to share it with.")
+		return
+	}
+	if len(recipients) > rainMaxRecipients {
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I can split an amount between at most %d users.", rainMaxRecipients))
+		return
+	}
+
+	shares, err := splitAmount(cmd.Amount, len(recipients))
+	if err != nil {
+		dm(p, m.Sender.ID, fmt.Sprintf("Sorry, I can't split that: %v.", err))
+		return
+	}
+
+	ws := make([]waiter, len(recipients))
+	names := make([]string, len(recipients))
+	for i, r := range recipients {
+		ws[i] = waiter{m.StatusID, m.Sender.ID, m.Sender.Name, r.ID, r.Name, shares[i].String(), cmd.Memo}
+		names[i] = "@" + r.Name
+	}
+
+	if refuseSpend(p, m.Sender.ID, cmd.Amount) {
+		return
+	}
+
+	c := confirmation{Platform: p.Name(), Waiter: waiter{
+		m.StatusID,
+		m.Sender.ID,
+		m.Sender.Name,
+		"",
+		fmt.Sprintf("%d users", len(recipients)),
+		cmd.Amount.String(),
+		cmd.Memo,
+	}, Deadline: time.Now().Add(confirmTimeout), Rain: ws}
+
+	msg := fmt.Sprintf("CONFIRMATION: Send %v NAS split between %v? (yes/NO)", formatNAS(cmd.Amount), joinNames(names))
+	if len(unknown) > 0 {
+		msg += fmt.Sprintf("\nI don't know %v, they're left out.", joinNames(unknown))
+	}
+	if truncated {
+		msg += "\nThat post is too old or has too many replies for me to find them all, some repliers may be left out."
+	}
+	askConfirmation(p, c, msg)
+}
+
+// startRain sends every share of the confirmed rain c and DMs one summary of the outcome.
+func startRain(p Platform, c confirmation) {
+	w := c.Waiter
+	dm(p, w.SenderID, "Starting transactions...")
+
+	err := checkRainBalance(w.SenderID, w.Amount, len(c.Rain))
+	if err != nil {
+		dm(p, w.SenderID, fmt.Sprintf("Transaction failed.\nReason: %v", err))
+		return
+	}
+
+	lines, rained := sendShares(p, c.Rain)
+	sent := len(rained)
+
+	summary := fmt.Sprintf("Rain of %v NAS: %d of %d transfers sent.\n", amountNAS(w.Amount), sent, len(c.Rain))
+	dm(p, w.SenderID, summary+strings.Join(lines, "\n"))
+
+	if sent > 0 {
+		err = p.Reply(w.StatusID, fmt.Sprintf("%v @%v made it rain on %v!", reaction(), w.SenderName, joinNames(rained)))
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// sendShares sends every tip of ws one after the other, so the nonces of the sender's
+// account are taken in order. It returns one line per tip for the sender's summary and
+// the handles of the recipients that were paid.
+func sendShares(p Platform, ws []waiter) (lines []string, paid []string) {
+	if len(ws) > 0 {
+		defer lockSpend(ws[0].SenderID)()
+	}
+	for _, rw := range ws {
+		rw := rw
+		hash, escrowID, err := sendTip(p, rw)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("@%v: failed. Reason: %v", rw.RecipientName, err))
+			continue
+		}
+
+		paid = append(paid, "@"+rw.RecipientName)
+		lines = append(lines, fmt.Sprintf("@%v: sent %v NAS. TX: %v", rw.RecipientName, amountNAS(rw.Amount), hash))
+
+		// The summary replaces the DM per transaction, but strangers still need to be told how to claim.
+		t := submittedTx{Hash: hash, SenderID: rw.SenderID, Quiet: true}
+		e := ledgerEntry{
+			Kind:          ledgerTip,
+			SenderID:      rw.SenderID,
+			SenderName:    rw.SenderName,
+			RecipientID:   rw.RecipientID,
+			RecipientName: rw.RecipientName,
+			Amount:        rw.Amount,
+			StatusID:      rw.StatusID,
+			Memo:          rw.Memo,
+		}
+		if escrowID != 0 {
+			t.Announce, t.Escrow = &rw, escrowID
+			e.Kind, e.RecipientID = ledgerEscrow, ""
+		}
+		trackTx(p, t, e)
+	}
+
+	return lines, paid
+}
+
+// checkRainBalance makes sure senderID can pay total and the fees of n transfers before
+// any of them is sent, rather than running dry halfway through.
+func checkRainBalance(senderID string, total string, n int) error {
+	acc, err := getAcc(senderID)
+	if err != nil {
+		return err
+	}
+	amount, err := util.NewUint128FromString(total)
+	if err != nil {
+		return err
+	}
+	price, limit, err := estimateNext(acc.addr, acc.addr, amount, core.TxPayloadBinaryType, nil)
+	if err != nil {
+		return err
+	}
+	limit, err = limit.Mul(uint128(uint64(n)))
+	if err != nil {
+		return err
+	}
+	return checkBalance(acc.addr, amount, price, limit)
+}
+
+// joinNames writes "@a, @b and @c".
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"./nebulas"
+)
+
+var errorTxFailed = errors.New("transaction execution failed")
+var errorTxTimeout = errors.New("timed out waiting for the transaction to be confirmed")
+
+// txTracker polls the node for transaction receipts until they leave the pending state.
+type txTracker struct {
+	node     *rpcClient
+	interval time.Duration
+	timeout  time.Duration
+}
+
+var tracker = &txTracker{node, 5 * time.Second, 5 * time.Minute}
+
+// wait blocks until the transaction succeeds, fails or the tracker times out.
+// A failed transaction returns its receipt together with errorTxFailed.
+func (t *txTracker) wait(hash string) (*txReceipt, error) {
+	deadline := time.Now().Add(t.timeout)
+
+	for {
+		// Errors are retried: the node doesn't know the hash until the tx reaches its pool.
+		r, err := t.node.transactionReceipt(hash)
+		if err == nil {
+			switch r.Status {
+			case core.TxExecutionSuccess:
+				return r, nil
+			case core.TxExecutionFailed:
+				return r, errorTxFailed
+			}
+		}
+
+		if time.Now().Add(t.interval).After(deadline) {
+			return r, errorTxTimeout
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+// recheck asks the node again about the transaction t that wait gave up on, and records
+// its outcome once it was executed. Until then it returns txPending.
+func (tr *txTracker) recheck(t submittedTx) string {
+	r, err := tr.node.transactionReceipt(t.Hash)
+	if err != nil {
+		return txPending
+	}
+	switch r.Status {
+	case core.TxExecutionSuccess:
+		t.Status = txSuccess
+	case core.TxExecutionFailed:
+		t.Status = txFailed
+	default:
+		return txPending
+	}
+
+	if err := state.putTx(t); err != nil {
+		fmt.Println(err)
+	}
+	if t.Ledger != 0 {
+		if err := state.setLedgerStatus(t.Ledger, t.Status); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return t.Status
+}
+
+// txOutcome turns the result of wait into a message for the sender.
+func txOutcome(hash string, r *txReceipt, err error) string {
+	switch err {
+	case nil:
+		return fmt.Sprintf("Transaction confirmed. TX: %v", hash)
+	case errorTxFailed:
+		return fmt.Sprintf("Transaction failed.\nReason: %v\nTX: %v", r.ExecuteError, hash)
+	default:
+		return fmt.Sprintf("Transaction is still pending, check again later. TX: %v", hash)
+	}
+}
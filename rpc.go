@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"./nebulas"
+	"./nebulas/util"
+)
+
+// rpcClient talks to the HTTP API of a Nebulas node.
+type rpcClient struct {
+	url  string
+	http *http.Client
+}
+
+func newRPCClient(url string) *rpcClient {
+	return &rpcClient{url, client}
+}
+
+// rpcError is returned when the node answers with a non-200 status or an "error" field.
+type rpcError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("node error (%v %d): %v", e.Path, e.StatusCode, e.Message)
+}
+
+type accountState struct {
+	Balance *util.Uint128
+	Nonce   uint64
+	Type    int
+}
+
+type callContract struct {
+	Function string `json:"function"`
+	Args     string `json:"args"`
+}
+
+type callRequest struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Value    string        `json:"value"`
+	Nonce    uint64        `json:"nonce"`
+	GasPrice string        `json:"gasPrice"`
+	GasLimit string        `json:"gasLimit"`
+	Type     string        `json:"type,omitempty"`
+	Contract *callContract `json:"contract,omitempty"`
+	Binary   []byte        `json:"binary,omitempty"`
+}
+
+type gasEstimate struct {
+	Gas string `json:"gas"`
+	Err string `json:"err"`
+}
+
+type txReceipt struct {
+	Hash         string `json:"hash"`
+	ChainID      uint32 `json:"chainId"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Value        string `json:"value"`
+	Nonce        string `json:"nonce"`
+	Type         string `json:"type"`
+	GasPrice     string `json:"gas_price"`
+	GasLimit     string `json:"gas_limit"`
+	GasUsed      string `json:"gas_used"`
+	Status       int    `json:"status"`
+	ExecuteError string `json:"execute_error"`
+}
+
+type nebState struct {
+	ChainID         uint32 `json:"chain_id"`
+	Tail            string `json:"tail"`
+	LIB             string `json:"lib"`
+	Height          string `json:"height"`
+	ProtocolVersion string `json:"protocol_version"`
+	Synchronized    bool   `json:"synchronized"`
+	Version         string `json:"version"`
+}
+
+// accountState returns the balance and nonce of an address.
+func (c *rpcClient) accountState(a *core.Address) (*accountState, error) {
+	var raw struct {
+		Balance string `json:"balance"`
+		Nonce   string `json:"nonce"`
+		Type    int    `json:"type"`
+	}
+	err := c.do("POST", "/v1/user/accountstate", map[string]string{"address": a.String()}, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := util.NewUint128FromString(raw.Balance)
+	if err != nil {
+		return nil, errorDecodeJSON
+	}
+
+	nonce, err := strconv.ParseUint(raw.Nonce, 10, 64)
+	if err != nil {
+		return nil, errorDecodeJSON
+	}
+
+	return &accountState{balance, nonce, raw.Type}, nil
+}
+
+// sendRawTransaction submits a signed transaction and returns its hash.
+func (c *rpcClient) sendRawTransaction(data string) (string, error) {
+	var r struct {
+		TxHash string `json:"txhash"`
+	}
+	err := c.do("POST", "/v1/user/rawtransaction", map[string]string{"data": data}, &r)
+	if err != nil {
+		return "", err
+	}
+
+	return r.TxHash, nil
+}
+
+// call executes a contract function locally on the node without submitting a transaction.
+func (c *rpcClient) call(req callRequest) (*result, error) {
+	r := result{}
+	err := c.do("POST", "/v1/user/call", req, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// transactionReceipt looks up a transaction by hash.
+func (c *rpcClient) transactionReceipt(hash string) (*txReceipt, error) {
+	r := txReceipt{}
+	err := c.do("POST", "/v1/user/getTransactionReceipt", map[string]string{"hash": hash}, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// estimateGas asks the node how much gas a transaction would use.
+func (c *rpcClient) estimateGas(req callRequest) (*gasEstimate, error) {
+	r := gasEstimate{}
+	err := c.do("POST", "/v1/user/estimateGas", req, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// gasPrice returns the current gas price of the network.
+func (c *rpcClient) gasPrice() (*util.Uint128, error) {
+	var r struct {
+		GasPrice string `json:"gas_price"`
+	}
+	err := c.do("GET", "/v1/user/getGasPrice", nil, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := util.NewUint128FromString(r.GasPrice)
+	if err != nil {
+		return nil, errorDecodeJSON
+	}
+
+	return price, nil
+}
+
+// nebState returns the state of the node and the tail of its chain.
+func (c *rpcClient) nebState() (*nebState, error) {
+	r := nebState{}
+	err := c.do("GET", "/v1/user/nebstate", nil, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// height returns the height of the tail of the node's chain.
+func (c *rpcClient) height() (uint64, error) {
+	s, err := c.nebState()
+	if err != nil {
+		return 0, err
+	}
+
+	h, err := strconv.ParseUint(s.Height, 10, 64)
+	if err != nil {
+		return 0, errorDecodeJSON
+	}
+	return h, nil
+}
+
+// do sends a request to the node and decodes the "result" field of the response into out.
+func (c *rpcClient) do(method, path string, in interface{}, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	err = json.Unmarshal(readBody(resp), &envelope)
+	if err != nil {
+		if resp.StatusCode != 200 {
+			return &rpcError{path, resp.StatusCode, resp.Status}
+		}
+		return errorDecodeJSON
+	}
+
+	if envelope.Error != "" || resp.StatusCode != 200 {
+		return &rpcError{path, resp.StatusCode, envelope.Error}
+	}
+
+	if len(envelope.Result) == 0 {
+		return errorDecodeJSON
+	}
+
+	err = json.Unmarshal(envelope.Result, out)
+	if err != nil {
+		return errorDecodeJSON
+	}
+
+	return nil
+}
+
+type blockTx struct {
+	Hash   string `json:"hash"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Value  string `json:"value"`
+	Type   string `json:"type"`
+	Status int    `json:"status"`
+}
+
+type block struct {
+	Hash   string `json:"hash"`
+	Height string `json:"height"`
+	// Timestamp is in seconds since the epoch.
+	Timestamp    string    `json:"timestamp"`
+	Transactions []blockTx `json:"transactions"`
+}
+
+// time returns when b was produced.
+func (b *block) time() (time.Time, error) {
+	ts, err := strconv.ParseInt(b.Timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, errorDecodeJSON
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// blockByHeight returns the block at height together with its transactions.
+func (c *rpcClient) blockByHeight(height uint64) (*block, error) {
+	r := block{}
+	err := c.do("POST", "/v1/user/getBlockByHeight", map[string]interface{}{"height": height, "full_fill_transaction": true}, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"./nebulas"
+	"./nebulas/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Status of a scheduled tip.
+const (
+	scheduleActive = "active"
+	// schedulePaused is a tip that stopped when its sender's balance ran too low, until they resume it.
+	schedulePaused    = "paused"
+	scheduleCancelled = "cancelled"
+	// scheduleDone is a tip that ran once and isn't repeated.
+	scheduleDone = "done"
+)
+
+// scheduleMinInterval is the shortest period a tip can repeat at.
+const scheduleMinInterval = time.Hour
+
+// scheduleSpec says when a scheduled tip runs. Exactly one of its fields is set.
+type scheduleSpec struct {
+	Every time.Duration `json:",omitempty"`
+	// At is the time of a tip that runs once.
+	At   time.Time `json:",omitempty"`
+	Cron string    `json:",omitempty"`
+}
+
+// schedule is a tip or transfer the bot sends on behalf of a user at a later time, or over and over.
+type schedule struct {
+	ID       uint64
+	Platform string
+	// Waiter has the sender, the amount and the recipient of a tip. It has no StatusID,
+	// scheduled tips aren't announced.
+	Waiter waiter
+	// To is the address of a transfer, which has no recipient.
+	To     string `json:",omitempty"`
+	When   scheduleSpec
+	Next   time.Time
+	Status string
+}
+
+// scheduleWatcher sends the scheduled tips that are due.
+type scheduleWatcher struct {
+	interval time.Duration
+}
+
+var schedules = &scheduleWatcher{time.Minute}
+
+// first returns when a schedule created at now runs first. A repeated tip runs one period
+// after it's created.
+func (w scheduleSpec) first(now time.Time) time.Time {
+	switch {
+	case w.Every > 0:
+		return now.Add(w.Every).UTC().Truncate(time.Minute)
+	case w.Cron != "":
+		c, err := parseCron(w.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return c.next(now)
+	default:
+		return w.At
+	}
+}
+
+// after returns the run that follows the one that was due, skipping the runs missed
+// before now, and false for tips that only run once.
+func (w scheduleSpec) after(due, now time.Time) (time.Time, bool) {
+	switch {
+	case w.Every > 0:
+		next := due.Add(w.Every)
+		for !next.After(now) {
+			next = next.Add(w.Every)
+		}
+		return next, true
+	case w.Cron != "":
+		c, err := parseCron(w.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next := c.next(now)
+		return next, !next.IsZero()
+	default:
+		return time.Time{}, false
+	}
+}
+
+func (w scheduleSpec) String() string {
+	switch {
+	case w.Every > 0:
+		return "every " + formatPeriod(w.Every)
+	case w.Cron != "":
+		return "on cron " + w.Cron
+	default:
+		return "on " + formatTime(w.At)
+	}
+}
+
+// formatTime writes times in UTC to the minute, the precision of schedules.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("Mon, 02 Jan 2006 15:04 MST")
+}
+
+// recipient names who sc pays.
+func (sc schedule) recipient() string {
+	if sc.To != "" {
+		return sc.To
+	}
+	return "@" + sc.Waiter.RecipientName
+}
+
+func (s *stateStore) addSchedule(sc *schedule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		sc.ID = id
+
+		data, err := json.Marshal(sc)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+}
+
+// updateSchedule calls f with schedule id and saves it if f reports true, all in one
+// transaction so the scheduler and the user's commands never overwrite each other.
+func (s *stateStore) updateSchedule(id uint64, f func(sc *schedule) bool) (sc schedule, ok bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+		data := b.Get(seqKey(id))
+		if data == nil {
+			return nil
+		}
+		err := json.Unmarshal(data, &sc)
+		if err != nil || !f(&sc) {
+			return err
+		}
+
+		ok = true
+		data, err = json.Marshal(sc)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+	return
+}
+
+// schedules returns the schedules that are active or paused, of senderID or of everyone
+// when senderID is empty.
+func (s *stateStore) schedules(senderID string) ([]schedule, error) {
+	var ss []schedule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(k, v []byte) error {
+			var sc schedule
+			err := json.Unmarshal(v, &sc)
+			if err != nil {
+				return err
+			}
+			if (sc.Status == scheduleActive || sc.Status == schedulePaused) && (senderID == "" || sc.Waiter.SenderID == senderID) {
+				ss = append(ss, sc)
+			}
+			return nil
+		})
+	})
+	return ss, err
+}
+
+// scheduleCmd creates the schedule of cmd for the sender of msg.
+func scheduleCmd(p Platform, msg directMessage, cmd chatCmd) {
+	w := waiter{SenderID: msg.Sender.ID, SenderName: msg.Sender.Name, Amount: cmd.Amount.String(), Memo: cmd.Memo}
+	sc := schedule{Platform: p.Name(), Waiter: w, When: cmd.When, Status: scheduleActive}
+	if cmd.To != nil {
+		sc.To = cmd.To.String()
+	} else {
+		r, err := p.LookupUser(cmd.Recipient)
+		if err != nil {
+			fmt.Println(err)
+			dm(p, msg.Sender.ID, fmt.Sprintf("Sorry, I don't know @%v.", cmd.Recipient))
+			return
+		}
+		if r.ID == msg.Sender.ID {
+			dm(p, msg.Sender.ID, "You can't schedule a tip to yourself.")
+			return
+		}
+		sc.Waiter.RecipientID, sc.Waiter.RecipientName = r.ID, r.Name
+	}
+
+	// The daily limit is up to each run, but a single run can't be over the limit.
+	l, err := userLimits(msg.Sender.ID)
+	if err == nil {
+		err = l.checkTx(cmd.Amount)
+	}
+	if err != nil {
+		dm(p, msg.Sender.ID, fmt.Sprintf("Sorry, %v.", err))
+		return
+	}
+
+	c := confirmation{Platform: p.Name(), Waiter: sc.Waiter, Schedule: &sc}
+	if needsCode(c) {
+		askCode(p, c)
+		return
+	}
+	addScheduleCmd(p, sc)
+}
+
+// addScheduleCmd stores the scheduled tip sc and DMs its sender when it runs.
+func addScheduleCmd(p Platform, sc schedule) {
+	w := sc.Waiter
+	sc.Next = sc.When.first(time.Now())
+	err := state.addSchedule(&sc)
+	if err != nil {
+		fmt.Println(err)
+		dm(p, w.SenderID, "Sorry, something went wrong.")
+		return
+	}
+
+	text := fmt.Sprintf("Scheduled %v NAS to %v %v", amountNAS(w.Amount), sc.recipient(), sc.When)
+	if sc.When.At.IsZero() {
+		text += fmt.Sprintf(", first on %v", formatTime(sc.Next))
+	}
+	dm(p, w.SenderID, fmt.Sprintf(`%v. It's number %d, DM me "schedule cancel %d" to stop it.`, text, sc.ID, sc.ID))
+}
+
+// listSchedulesCmd DMs userID their scheduled tips.
+func listSchedulesCmd(p Platform, userID string) {
+	ss, err := state.schedules(userID)
+	if err != nil {
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+		return
+	}
+	if len(ss) == 0 {
+		dm(p, userID, `You have no scheduled tips. Schedule one like "schedule 1 NAS to @name every week".`)
+		return
+	}
+
+	lines := []string{"Your scheduled tips:"}
+	for _, sc := range ss {
+		line := fmt.Sprintf("%d. %v NAS to %v %v", sc.ID, amountNAS(sc.Waiter.Amount), sc.recipient(), sc.When)
+		if sc.Status == schedulePaused {
+			line += ", paused"
+		} else if sc.When.At.IsZero() {
+			line += ", next on " + formatTime(sc.Next)
+		}
+		lines = append(lines, line)
+	}
+	dm(p, userID, strings.Join(lines, "\n"))
+}
+
+// setScheduleCmd cancels or resumes schedule id of userID, depending on the status to.
+func setScheduleCmd(p Platform, userID string, id uint64, to string) {
+	sc, ok, err := state.updateSchedule(id, func(sc *schedule) bool {
+		if sc.Waiter.SenderID != userID {
+			return false
+		}
+		switch {
+		case to == scheduleCancelled && (sc.Status == scheduleActive || sc.Status == schedulePaused):
+		case to == scheduleActive && sc.Status == schedulePaused:
+		default:
+			return false
+		}
+		sc.Status = to
+		return true
+	})
+	switch {
+	case err != nil:
+		fmt.Println(err)
+		dm(p, userID, "Sorry, something went wrong.")
+	case !ok && to == scheduleActive:
+		dm(p, userID, fmt.Sprintf("You have no paused scheduled tip number %d.", id))
+	case !ok:
+		dm(p, userID, fmt.Sprintf("You have no scheduled tip number %d.", id))
+	case to == scheduleActive:
+		dm(p, userID, fmt.Sprintf("Resumed scheduled tip %d of %v NAS to %v.", id, amountNAS(sc.Waiter.Amount), sc.recipient()))
+	default:
+		dm(p, userID, fmt.Sprintf("Cancelled scheduled tip %d of %v NAS to %v.", id, amountNAS(sc.Waiter.Amount), sc.recipient()))
+	}
+}
+
+func (w *scheduleWatcher) run(ps []Platform) {
+	byName := map[string]Platform{}
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+
+	for {
+		err := w.send(byName, time.Now())
+		if err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// send runs the schedules that are due by now.
+func (w *scheduleWatcher) send(byName map[string]Platform, now time.Time) error {
+	ss, err := state.schedules("")
+	if err != nil {
+		return err
+	}
+
+	for _, sc := range ss {
+		p, ok := byName[sc.Platform]
+		if !ok || sc.Status != scheduleActive || sc.Next.After(now) {
+			continue
+		}
+		runSchedule(p, sc, now)
+	}
+	return nil
+}
+
+// runSchedule sends the tip of sc that was due and DMs a receipt to its sender. The next
+// run is stored first, so a restart can't send the same run twice. A sender who can't
+// pay has the schedule paused, and the run is sent once they resume it.
+func runSchedule(p Platform, sc schedule, now time.Time) {
+	due := sc.Next
+	sc, ok, err := state.updateSchedule(sc.ID, func(sc *schedule) bool {
+		if sc.Status != scheduleActive || !sc.Next.Equal(due) {
+			return false
+		}
+		next, repeats := sc.When.after(due, now)
+		sc.Next = next
+		if !repeats {
+			sc.Status = scheduleDone
+		}
+		return true
+	})
+	if err != nil || !ok {
+		// Cancelled or paused in the meantime.
+		return
+	}
+
+	w := sc.Waiter
+	hash, err := sendSchedule(p, sc)
+	if _, ok := err.(*balanceError); ok {
+		state.updateSchedule(sc.ID, func(s *schedule) bool {
+			if s.Status == scheduleCancelled {
+				return false
+			}
+			s.Next, s.Status = due, schedulePaused
+			return true
+		})
+		dm(p, w.SenderID, fmt.Sprintf(`Scheduled tip %d of %v NAS to %v is paused, your balance is too low. DM me "schedule resume %d" once you've topped up.`, sc.ID, amountNAS(w.Amount), sc.recipient(), sc.ID))
+		return
+	}
+	if err != nil {
+		dm(p, w.SenderID, fmt.Sprintf("Scheduled tip %d of %v NAS to %v failed.\nReason: %v", sc.ID, amountNAS(w.Amount), sc.recipient(), err))
+		return
+	}
+
+	receipt := fmt.Sprintf("Scheduled tip %d: sending %v NAS to %v. TX: %v", sc.ID, amountNAS(w.Amount), sc.recipient(), hash)
+	if sc.Status == scheduleActive {
+		receipt += fmt.Sprintf("\nNext on %v.", formatTime(sc.Next))
+	}
+	dm(p, w.SenderID, receipt)
+}
+
+// sendSchedule sends one run of sc through the same path as a tip or transfer, and tracks it.
+func sendSchedule(p Platform, sc schedule) (string, error) {
+	w := sc.Waiter
+	defer lockSpend(w.SenderID)()
+	if sc.To == "" {
+		hash, escrowID, err := sendTip(p, w)
+		if err != nil {
+			return "", err
+		}
+
+		// The transaction's outcome is the rest of the receipt.
+		t := submittedTx{Hash: hash, SenderID: w.SenderID}
+		e := ledgerEntry{
+			Kind:          ledgerTip,
+			SenderID:      w.SenderID,
+			SenderName:    w.SenderName,
+			RecipientID:   w.RecipientID,
+			RecipientName: w.RecipientName,
+			Amount:        w.Amount,
+			Memo:          w.Memo,
+		}
+		if escrowID != 0 {
+			t.Announce, t.Escrow = &w, escrowID
+			e.Kind, e.RecipientID = ledgerEscrow, ""
+		}
+		trackTx(p, t, e)
+		return hash, nil
+	}
+
+	acc, err := getAcc(w.SenderID)
+	if err != nil {
+		return "", err
+	}
+	to, err := core.AddressParse(sc.To)
+	if err != nil {
+		return "", err
+	}
+	amount, err := util.NewUint128FromString(w.Amount)
+	if err != nil {
+		return "", err
+	}
+	err = checkSpend(w.SenderID, amount)
+	if err != nil {
+		return "", err
+	}
+	hash, err := submit(acc, to, amount, core.TxPayloadBinaryType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	trackTx(p, submittedTx{Hash: hash, SenderID: w.SenderID}, ledgerEntry{
+		Kind:       ledgerTransfer,
+		SenderID:   w.SenderID,
+		SenderName: w.SenderName,
+		To:         sc.To,
+		Amount:     w.Amount,
+		Memo:       w.Memo,
+	})
+	return hash, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errorSlackSignature = errors.New("invalid slack request signature")
+var errorSlackTimestamp = errors.New("slack request is too old")
+var errorNotSlackUser = errors.New("not a slack user ID")
+var errorSlackSecret = errors.New("slackSigningSecret must be set to enable slack")
+
+const slackPrefix = "slack:"
+
+// slack is the Platform adapter for a Slack app. Users talk to it with the /nas slash
+// command and answer confirmations with buttons, both posted to an HTTP endpoint the
+// bot serves. User IDs are "slack:" + Slack user ID.
+type slack struct {
+	token         string
+	signingSecret string
+	addr          string
+	api           string
+}
+
+func newSlack(getenv func(string) string) *slack {
+	addr := getenv("slackListen")
+	if addr == "" {
+		addr = ":3000"
+	}
+	api := getenv("slackAPI")
+	if api == "" {
+		api = "https://slack.com/api"
+	}
+	return &slack{
+		token:         getenv("slackToken"),
+		signingSecret: getenv("slackSigningSecret"),
+		addr:          addr,
+		api:           strings.TrimSuffix(api, "/"),
+	}
+}
+
+func (s *slack) Name() string {
+	return "slack"
+}
+
+// Listen serves the slash command and interactivity endpoints, configure the app
+// to post to /slack/commands and /slack/actions.
+func (s *slack) Listen(events chan<- interface{}) error {
+	return http.ListenAndServe(s.addr, s.handler(events))
+}
+
+func (s *slack) handler(events chan<- interface{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/commands", s.verified(func(form url.Values) interface{} {
+		return s.command(form)
+	}, events))
+	mux.HandleFunc("/slack/actions", s.verified(func(form url.Values) interface{} {
+		return s.action(form.Get("payload"))
+	}, events))
+	return mux
+}
+
+// verified checks the signature of a Slack request before handing its form to parse.
+// The event parse returns is sent on after answering, Slack gives up on us after 3 seconds.
+func (s *slack) verified(parse func(url.Values) interface{}, events chan<- interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		err = verifySlackSignature(s.signingSecret, r.Header, body, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(401)
+			return
+		}
+
+		// We already got the first attempt, a slow answer doesn't mean it was lost.
+		if r.Header.Get("X-Slack-Retry-Num") != "" {
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		if e := parse(form); e != nil {
+			go func() { events <- e }()
+		}
+	}
+}
+
+// verifySlackSignature checks the v0 HMAC Slack puts in X-Slack-Signature and refuses
+// requests more than five minutes old, so captured requests can't be replayed.
+func verifySlackSignature(secret string, header http.Header, body []byte, now time.Time) error {
+	// Anyone can sign with an empty secret.
+	if secret == "" {
+		return errorSlackSignature
+	}
+	ts, err := strconv.ParseInt(header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return errorSlackSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > 5*time.Minute || d < -5*time.Minute {
+		return errorSlackTimestamp
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:%s", ts, body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(want), []byte(header.Get("X-Slack-Signature"))) {
+		return errorSlackSignature
+	}
+	return nil
+}
+
+var slackUserRef = regexp.MustCompile(`^<@([A-Z0-9]+)(\|([^>]*))?>$`)
+
+// command turns "/nas tip @user 5" into a mention and any other "/nas ..." into a DM command.
+func (s *slack) command(form url.Values) interface{} {
+	sender := user{slackPrefix + form.Get("user_id"), form.Get("user_name")}
+	fields := strings.Fields(form.Get("text"))
+
+	if len(fields) < 3 || strings.ToLower(fields[0]) != "tip" {
+		return directMessage{sender, strings.Join(fields, " "), ""}
+	}
+
+	var recipient user
+	if m := slackUserRef.FindStringSubmatch(fields[1]); m != nil {
+		recipient = user{slackPrefix + m[1], m[3]}
+	} else {
+		var err error
+		recipient, err = s.LookupUser(fields[1])
+		if err != nil {
+			dm(s, sender.ID, fmt.Sprintf("Sorry, I don't know %v.", fields[1]))
+			return nil
+		}
+	}
+
+	amount := strings.Join(fields[2:], " ")
+	if !strings.HasSuffix(strings.ToUpper(amount), " NAS") {
+		amount += " NAS"
+	}
+	return mention{
+		StatusID:  form.Get("channel_id"),
+		Sender:    sender,
+		Recipient: recipient,
+		Text:      "@NebBot send " + amount,
+	}
+}
+
+// action turns a click on a confirmation button into the answer the user would have typed.
+func (s *slack) action(payload string) interface{} {
+	var p struct {
+		Type string `json:"type"`
+		User struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+		Message struct {
+			Text string `json:"text"`
+		} `json:"message"`
+		ResponseURL string `json:"response_url"`
+	}
+	if json.Unmarshal([]byte(payload), &p) != nil || p.Type != "block_actions" || len(p.Actions) != 1 {
+		return nil
+	}
+
+	// Buttons carry "yes:<confirmation ID>", so a click on an old prompt can't answer a newer one.
+	value := strings.SplitN(p.Actions[0].Value, ":", 2)
+	if len(value) != 2 || value[0] != "yes" && value[0] != "no" || value[1] == "" {
+		return nil
+	}
+	answer, id := value[0], value[1]
+
+	// Swap the buttons for the answer so the prompt can't be clicked twice.
+	err := doJSON("POST", p.ResponseURL, nil, map[string]interface{}{
+		"replace_original": true,
+		"text":             p.Message.Text + " " + answer,
+	}, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return directMessage{user{slackPrefix + p.User.ID, p.User.Username}, answer, id}
+}
+
+func (s *slack) SendDM(userID string, text string) error {
+	if !strings.HasPrefix(userID, slackPrefix) {
+		return errorNotSlackUser
+	}
+	return s.do("chat.postMessage", map[string]interface{}{"channel": userID[len(slackPrefix):], "text": text}, nil)
+}
+
+// SendConfirmation asks for a yes or no with buttons, which the actions endpoint
+// turns back into the typed answer confirmUserTxResponse expects.
+func (s *slack) SendConfirmation(userID string, text string, confirmationID string) error {
+	if !strings.HasPrefix(userID, slackPrefix) {
+		return errorNotSlackUser
+	}
+
+	button := func(label, answer, style string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":      "button",
+			"action_id": answer,
+			"value":     answer + ":" + confirmationID,
+			"style":     style,
+			"text":      map[string]string{"type": "plain_text", "text": label},
+		}
+	}
+	return s.do("chat.postMessage", map[string]interface{}{
+		"channel": userID[len(slackPrefix):],
+		"text":    text,
+		"blocks": []interface{}{
+			map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+			map[string]interface{}{"type": "actions", "elements": []interface{}{
+				button("Yes", "yes", "primary"),
+				button("No", "no", "danger"),
+			}},
+		},
+	}, nil)
+}
+
+// Reply posts in the channel the slash command was used in, slash commands have no message to thread on.
+func (s *slack) Reply(statusID string, text string) error {
+	return s.do("chat.postMessage", map[string]interface{}{"channel": statusID, "text": text}, nil)
+}
+
+func (s *slack) LookupUser(name string) (user, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "@"))
+
+	cursor := ""
+	for {
+		var r struct {
+			Members []struct {
+				ID      string `json:"id"`
+				Name    string `json:"name"`
+				Deleted bool   `json:"deleted"`
+				Profile struct {
+					DisplayName string `json:"display_name"`
+				} `json:"profile"`
+			} `json:"members"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		err := s.do("users.list", map[string]interface{}{"limit": 200, "cursor": cursor}, &r)
+		if err != nil {
+			return user{}, err
+		}
+
+		for _, m := range r.Members {
+			if !m.Deleted && (strings.ToLower(m.Name) == name || strings.ToLower(m.Profile.DisplayName) == name) {
+				return user{slackPrefix + m.ID, m.Name}, nil
+			}
+		}
+
+		cursor = r.Metadata.NextCursor
+		if cursor == "" {
+			return user{}, errorUnknownUser
+		}
+	}
+}
+
+// do calls a Web API method, which answer 200 with "ok": false when they fail.
+func (s *slack) do(method string, in interface{}, out interface{}) error {
+	var raw json.RawMessage
+	header := http.Header{"Authorization": {"Bearer " + s.token}}
+	err := doJSON("POST", s.api+"/"+method, header, in, &raw)
+	if err != nil {
+		return err
+	}
+
+	var r struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(raw, &r) != nil {
+		return errorDecodeJSON
+	}
+	if !r.OK {
+		return fmt.Errorf("slack %v: %v", method, r.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if json.Unmarshal(raw, out) != nil {
+		return errorDecodeJSON
+	}
+	return nil
+}
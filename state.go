@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	confirmationsBucket = []byte("confirmations")
+	addressesBucket     = []byte("addresses")
+	txsBucket           = []byte("txs")
+	watchedBucket       = []byte("watched")
+	watcherBucket       = []byte("watcher")
+	ledgerBucket        = []byte("ledger")
+	ledgerUsersBucket   = []byte("ledgerUsers")
+	escrowsBucket       = []byte("escrows")
+	giveawaysBucket     = []byte("giveaways")
+	schedulesBucket     = []byte("schedules")
+	limitsBucket        = []byte("limits")
+
+	scannedHeightKey = []byte("height")
+)
+
+// Status of a submitted transaction.
+const (
+	txPending = "pending"
+	txSuccess = "success"
+	txFailed  = "failed"
+	// txUnknown is a transaction we stopped waiting for before it was executed.
+	txUnknown = "unknown"
+)
+
+// confirmation is a tip waiting for the sender to answer yes or no.
+type confirmation struct {
+	Platform string
+	Waiter   waiter
+	Deadline time.Time
+	// Rain holds the share of every recipient when the tip is split between many.
+	// Waiter then has the total and no recipient.
+	Rain []waiter `json:",omitempty"`
+	// Giveaway is set when the sender is confirming a giveaway rather than a tip.
+	Giveaway *giveaway `json:",omitempty"`
+	// To is the address of a transfer, and Schedule a scheduled tip, when the sender is
+	// confirming one of those.
+	To       string    `json:",omitempty"`
+	Schedule *schedule `json:",omitempty"`
+	// Code is the one-time code the sender has to type back to confirm a large amount.
+	Code string `json:",omitempty"`
+}
+
+// submittedTx is a transaction the bot sent and reports the outcome of.
+type submittedTx struct {
+	Hash     string
+	Platform string
+	SenderID string
+	// Announce is the tip to reply to publicly once the transaction succeeds.
+	Announce *waiter `json:",omitempty"`
+	// Ledger is the sequence number of the transaction's ledger entry.
+	Ledger uint64 `json:",omitempty"`
+	// Escrow is the ID of the escrow the transaction pays into.
+	Escrow uint64 `json:",omitempty"`
+	// Quiet transactions don't DM their outcome, they're part of a rain that sent a summary.
+	Quiet     bool `json:",omitempty"`
+	Status    string
+	Submitted time.Time
+}
+
+// stateStore keeps everything the bot is in the middle of in a BoltDB file,
+// so a restart doesn't drop pending confirmations or transactions.
+type stateStore struct {
+	db *bolt.DB
+}
+
+// state is opened by main, and by TestMain for the tests.
+var state *stateStore
+
+func openState(path string) (*stateStore, error) {
+	// A second bot on the same file would answer every DM twice, fail instead of waiting for the lock.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{confirmationsBucket, addressesBucket, txsBucket, watchedBucket, watcherBucket, ledgerBucket, ledgerUsersBucket, escrowsBucket, giveawaysBucket, schedulesBucket, limitsBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &stateStore{db}, nil
+}
+
+func (s *stateStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *stateStore) putConfirmation(c confirmation) error {
+	return s.put(confirmationsBucket, c.Waiter.SenderID, c)
+}
+
+func (s *stateStore) confirmation(senderID string) (c confirmation, ok bool, err error) {
+	ok, err = s.get(confirmationsBucket, senderID, &c)
+	return
+}
+
+// takeConfirmation removes and returns the confirmation of senderID, so that
+// answering twice can never start the transaction twice. One past its deadline is
+// left for expireConfirmation, and isn't returned. A non-empty id only takes the
+// confirmation with that confirmationID.
+func (s *stateStore) takeConfirmation(senderID string, id string) (c confirmation, ok bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(confirmationsBucket)
+		data := b.Get([]byte(senderID))
+		if data == nil {
+			return nil
+		}
+		err := json.Unmarshal(data, &c)
+		if err != nil || time.Now().After(c.Deadline) || id != "" && id != confirmationID(c) {
+			return err
+		}
+		ok = true
+		return b.Delete([]byte(senderID))
+	})
+	return
+}
+
+// confirmationID identifies c among the confirmations its sender was asked for. Each one
+// gets its own deadline, which expireConfirmation already tells them apart by.
+func confirmationID(c confirmation) string {
+	return strconv.FormatInt(c.Deadline.UnixNano(), 10)
+}
+
+// expireConfirmation removes the confirmation of senderID if it still has the given deadline,
+// and reports whether it did. A newer confirmation of the same sender is left alone.
+func (s *stateStore) expireConfirmation(senderID string, deadline time.Time) (expired bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(confirmationsBucket)
+		var c confirmation
+		data := b.Get([]byte(senderID))
+		if data == nil || json.Unmarshal(data, &c) != nil || !c.Deadline.Equal(deadline) {
+			return nil
+		}
+		expired = true
+		return b.Delete([]byte(senderID))
+	})
+	return
+}
+
+func (s *stateStore) deleteConfirmation(senderID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(confirmationsBucket).Delete([]byte(senderID))
+	})
+}
+
+func (s *stateStore) confirmations() ([]confirmation, error) {
+	var cs []confirmation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(confirmationsBucket).ForEach(func(k, v []byte) error {
+			var c confirmation
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			cs = append(cs, c)
+			return nil
+		})
+	})
+	return cs, err
+}
+
+// reserveAddress marks that a key is being generated for id for the next d, and reports
+// false if another reservation for id hasn't run out yet.
+func (s *stateStore) reserveAddress(id string, d time.Duration) (ok bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(addressesBucket)
+		var until time.Time
+		if data := b.Get([]byte(id)); data != nil && json.Unmarshal(data, &until) == nil && time.Now().Before(until) {
+			return nil
+		}
+
+		ok = true
+		data, err := json.Marshal(time.Now().Add(d))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+	return
+}
+
+func (s *stateStore) putTx(t submittedTx) error {
+	return s.put(txsBucket, t.Hash, t)
+}
+
+func (s *stateStore) tx(hash string) (t submittedTx, ok bool, err error) {
+	ok, err = s.get(txsBucket, hash, &t)
+	return
+}
+
+// pendingTxs returns the transactions whose outcome hasn't been reported yet.
+func (s *stateStore) pendingTxs() ([]submittedTx, error) {
+	var ts []submittedTx
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(txsBucket).ForEach(func(k, v []byte) error {
+			var t submittedTx
+			err := json.Unmarshal(v, &t)
+			if err != nil {
+				return err
+			}
+			if t.Status == txPending {
+				ts = append(ts, t)
+			}
+			return nil
+		})
+	})
+	return ts, err
+}
+
+func (s *stateStore) watchAddress(addr string, u watchedUser) error {
+	return s.put(watchedBucket, addr, u)
+}
+
+func (s *stateStore) watched(addr string) (u watchedUser, ok bool, err error) {
+	ok, err = s.get(watchedBucket, addr, &u)
+	return
+}
+
+// userIDs returns the users the bot has dealt with: the ones with a watched address
+// or a ledger.
+func (s *stateStore) userIDs() ([]string, error) {
+	seen := map[string]bool{}
+	var ids []string
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(watchedBucket).ForEach(func(k, v []byte) error {
+			var u watchedUser
+			err := json.Unmarshal(v, &u)
+			if err == nil {
+				add(u.UserID)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(ledgerUsersBucket).ForEach(func(k, v []byte) error {
+			add(string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// scannedHeight returns the last block the deposit watcher has checked.
+func (s *stateStore) scannedHeight() (height uint64, ok bool, err error) {
+	ok, err = s.get(watcherBucket, string(scannedHeightKey), &height)
+	return
+}
+
+func (s *stateStore) setScannedHeight(height uint64) error {
+	return s.put(watcherBucket, string(scannedHeightKey), height)
+}
+
+func (s *stateStore) put(bucket []byte, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+func (s *stateStore) get(bucket []byte, key string, v interface{}) (bool, error) {
+	var data []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid inside the transaction.
+		data = append(data, tx.Bucket(bucket).Get([]byte(key))...)
+		return nil
+	})
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
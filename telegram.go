@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errorNotTelegramUser = errors.New("not a telegram user ID")
+
+// telegramPrefix keeps Telegram user IDs apart from the bare numeric Twitter IDs in the key store.
+const telegramPrefix = "tg:"
+
+// telegram is the Platform adapter for the Telegram Bot API, read with getUpdates long polling.
+type telegram struct {
+	token string
+	api   string
+
+	mu          sync.Mutex
+	botUsername string
+	// users remembers the ID of every username seen, the Bot API can't look them up.
+	users map[string]user
+}
+
+func newTelegram(getenv func(string) string) *telegram {
+	api := getenv("telegramAPI")
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	return &telegram{
+		token: getenv("telegramToken"),
+		api:   strings.TrimSuffix(api, "/"),
+		users: map[string]user{},
+	}
+}
+
+type telegramUser struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+type telegramMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *telegramUser `json:"from"`
+	Chat      struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	} `json:"chat"`
+	Text           string           `json:"text"`
+	ReplyToMessage *telegramMessage `json:"reply_to_message"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+func (t *telegram) Name() string {
+	return "telegram"
+}
+
+// Listen long polls getUpdates until the token is rejected.
+func (t *telegram) Listen(events chan<- interface{}) error {
+	var me telegramUser
+	err := t.do("getMe", nil, &me)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.botUsername = me.Username
+	t.mu.Unlock()
+
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := t.do("getUpdates", map[string]interface{}{"offset": offset, "timeout": 30}, &updates)
+		if e, ok := err.(*httpError); ok && e.StatusCode == 401 {
+			return err
+		} else if err != nil {
+			fmt.Println(err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil && u.Message.From != nil && !u.Message.From.IsBot {
+				t.handle(u.Message, events)
+			}
+		}
+	}
+}
+
+func (t *telegram) handle(msg *telegramMessage, events chan<- interface{}) {
+	sender := t.remember(msg.From)
+
+	if msg.Chat.Type == "private" {
+		events <- directMessage{sender, t.command(msg.Text), ""}
+		return
+	}
+
+	text := t.plainText(msg.Text)
+	if !strings.Contains(text, "@NebBot") {
+		return
+	}
+
+	m := mention{
+		StatusID: strconv.FormatInt(msg.Chat.ID, 10) + "/" + strconv.FormatInt(msg.MessageID, 10),
+		Sender:   sender,
+		Text:     text,
+	}
+	if r := msg.ReplyToMessage; r != nil && r.From != nil && !r.From.IsBot {
+		m.Recipient = t.remember(r.From)
+	}
+	events <- m
+}
+
+// command strips the slash and bot suffix off DM commands, "/help@NebBot" becomes "help".
+func (t *telegram) command(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return text
+	}
+
+	fields := strings.SplitN(text[1:], " ", 2)
+	fields[0] = strings.SplitN(fields[0], "@", 2)[0]
+	return strings.Join(fields, " ")
+}
+
+// plainText turns "/tip 5 NAS" and "@botusername ..." into the "@NebBot send 5 NAS" form parseStatus reads.
+func (t *telegram) plainText(text string) string {
+	t.mu.Lock()
+	botUsername := t.botUsername
+	t.mu.Unlock()
+
+	if strings.HasPrefix(text, "/tip ") || strings.HasPrefix(text, "/tip@"+botUsername+" ") {
+		return "@NebBot send " + strings.SplitN(text, " ", 2)[1]
+	}
+	if botUsername != "" {
+		text = strings.Replace(text, "@"+botUsername, "@NebBot", -1)
+	}
+	return text
+}
+
+func (t *telegram) remember(from *telegramUser) user {
+	u := user{telegramPrefix + strconv.FormatInt(from.ID, 10), from.Username}
+	if u.Name == "" {
+		u.Name = from.FirstName
+		return u
+	}
+
+	t.mu.Lock()
+	t.users[strings.ToLower(from.Username)] = u
+	t.mu.Unlock()
+	return u
+}
+
+// SendDM writes to the private chat with the user, which Telegram only allows
+// after the user has started a conversation with the bot.
+func (t *telegram) SendDM(userID string, text string) error {
+	if !strings.HasPrefix(userID, telegramPrefix) {
+		return errorNotTelegramUser
+	}
+	id, err := strconv.ParseInt(userID[len(telegramPrefix):], 10, 64)
+	if err != nil {
+		return errorNotTelegramUser
+	}
+
+	return t.do("sendMessage", map[string]interface{}{"chat_id": id, "text": text}, nil)
+}
+
+func (t *telegram) Reply(statusID string, text string) error {
+	ids := strings.SplitN(statusID, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("invalid telegram message ID %q", statusID)
+	}
+	chat, err := strconv.ParseInt(ids[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	msg, err := strconv.ParseInt(ids[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return t.do("sendMessage", map[string]interface{}{"chat_id": chat, "text": text, "reply_to_message_id": msg}, nil)
+}
+
+func (t *telegram) LookupUser(name string) (user, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.users[strings.ToLower(strings.TrimPrefix(name, "@"))]
+	if !ok {
+		return user{}, errorUnknownUser
+	}
+	return u, nil
+}
+
+// do calls a Bot API method and decodes the "result" field of the response into out.
+func (t *telegram) do(method string, params interface{}, out interface{}) error {
+	var r struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+	}
+	err := doJSON("POST", t.api+"/bot"+t.token+"/"+method, nil, params, &r)
+	// The URL holds the token, keep it out of the logs.
+	switch e := err.(type) {
+	case nil:
+	case *httpError:
+		e.URL = "telegram " + method
+		return e
+	case *url.Error:
+		e.URL = "telegram " + method
+		return e
+	default:
+		return err
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram %v: %v", method, r.Description)
+	}
+
+	if out == nil {
+		return nil
+	}
+	err = json.Unmarshal(r.Result, out)
+	if err != nil {
+		return errorDecodeJSON
+	}
+	return nil
+}
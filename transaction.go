@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/base64"
-	"fmt"
 
 	"./nebulas"
 	"./nebulas/crypto"
@@ -24,7 +23,7 @@ type txParams struct {
 
 func newTx(p txParams) (*core.Transaction, error) {
 	return core.NewTransaction(
-		chainID,
+		active.chainID,
 		p.to,
 		p.from,
 		p.value,
@@ -47,10 +46,7 @@ func encodeRawTx(tx *core.Transaction) (string, error) {
 		return "", err
 	}
 
-	data := base64.StdEncoding.EncodeToString(wired)
-	val := fmt.Sprintf(`{"data": %q}`, data)
-
-	return val, nil
+	return base64.StdEncoding.EncodeToString(wired), nil
 }
 
 func signTransaction(account account, tx *core.Transaction) error {
@@ -62,3 +58,78 @@ func signTransaction(account account, tx *core.Transaction) error {
 	sig.InitSign(account.priv)
 	return tx.Sign(sig)
 }
+
+// sendTx signs tx with account, checks it and submits it to the node. It returns the tx hash.
+func sendTx(account account, tx *core.Transaction) (string, error) {
+	err := signTransaction(account, tx)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.VerifyIntegrity(active.chainID)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := encodeRawTx(tx)
+	if err != nil {
+		return "", err
+	}
+
+	return node.sendRawTransaction(encoded)
+}
+
+// submit builds a transaction from account with the next free nonce and sends it.
+// A nonce rejection resyncs the account with the node and is retried once.
+func submit(account account, to *core.Address, value *util.Uint128, txtype string, payload []byte) (string, error) {
+	hash, _, err := submitTx(account, to, value, false, txtype, payload)
+	return hash, err
+}
+
+// submitLessFee is submit with the fee taken off value rather than paid on top of it.
+// It returns the value that was sent.
+func submitLessFee(account account, to *core.Address, value *util.Uint128, txtype string, payload []byte) (string, *util.Uint128, error) {
+	return submitTx(account, to, value, true, txtype, payload)
+}
+
+func submitTx(account account, to *core.Address, value *util.Uint128, feeFromValue bool, txtype string, payload []byte) (string, *util.Uint128, error) {
+	hash, sent, err := submitOnce(account, to, value, feeFromValue, txtype, payload)
+	if isNonceError(err) {
+		nonces.resync(account.addr)
+		hash, sent, err = submitOnce(account, to, value, feeFromValue, txtype, payload)
+	}
+	return hash, sent, err
+}
+
+func submitOnce(account account, to *core.Address, value *util.Uint128, feeFromValue bool, txtype string, payload []byte) (string, *util.Uint128, error) {
+	nonce, err := nonces.next(account.addr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	price, limit, err := estimateFee(account.addr, to, value, nonce, txtype, payload)
+	if err == nil && feeFromValue {
+		value, err = lessFee(value, price, limit)
+	}
+	if err == nil {
+		err = checkBalance(account.addr, value, price, limit)
+	}
+
+	var tx *core.Transaction
+	if err == nil {
+		tx, err = newTx(txParams{account.addr, to, value, nonce, price, limit, txtype, payload})
+	}
+
+	if err == nil {
+		var hash string
+		hash, err = sendTx(account, tx)
+		if err == nil {
+			return hash, value, nil
+		}
+	}
+
+	if !isNonceError(err) {
+		nonces.release(account.addr, nonce)
+	}
+	return "", nil, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+const botID int64 = 997554387227684865
+
+// twitterSearchWindow is how far back the standard search API goes.
+const twitterSearchWindow = 7 * 24 * time.Hour
+
+// twitterSearchPages caps how many pages of 100 tweets Replies searches through.
+const twitterSearchPages = 20
+
+// twitter is the Platform adapter for Twitter. Its user IDs are the bare numeric
+// Twitter IDs, which is how keys have always been stored.
+type twitter struct {
+	api *anaconda.TwitterApi
+}
+
+func newTwitter(getenv func(string) string) *twitter {
+	return &twitter{anaconda.NewTwitterApiWithCredentials(
+		getenv("accessToken"),
+		getenv("accessSecret"),
+		getenv("consumerKey"),
+		getenv("consumerSecret"),
+	)}
+}
+
+func (t *twitter) Name() string {
+	return "twitter"
+}
+
+func (t *twitter) Listen(events chan<- interface{}) error {
+	userStream := t.api.UserStream(nil)
+
+	for e := range userStream.C {
+		switch status := e.(type) {
+		case anaconda.Tweet:
+			if status.User.Id == botID {
+				continue
+			}
+			m := mention{
+				StatusID: status.IdStr,
+				Sender:   user{status.User.IdStr, status.User.ScreenName},
+				Text:     status.Text,
+			}
+			if status.InReplyToStatusID != 0 {
+				m.Recipient = user{status.InReplyToUserIdStr, status.InReplyToScreenName}
+				m.ReplyTo = status.InReplyToStatusIdStr
+			}
+			events <- m
+		case anaconda.DirectMessage:
+			events <- directMessage{user{strconv.FormatInt(status.SenderId, 10), status.SenderScreenName}, status.Text, ""}
+		default:
+		}
+	}
+	return nil
+}
+
+func (t *twitter) SendDM(userID string, text string) error {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.api.PostDMToUserId(text, id)
+	return err
+}
+
+func (t *twitter) Reply(statusID string, text string) error {
+	v := url.Values{}
+	v.Add("in_reply_to_status_id", statusID)
+
+	_, err := t.api.PostTweet(text, v)
+	return err
+}
+
+func (t *twitter) LookupUser(name string) (user, error) {
+	u, err := t.api.GetUsersShow(name, nil)
+	if err != nil {
+		return user{}, err
+	}
+	return user{u.IdStr, u.ScreenName}, nil
+}
+
+// Replies searches the recent tweets to the author of statusID for the ones replying to it,
+// the standard API has no direct way to list replies. Replies older than the search window
+// are missed, and reported with errorRepliesTruncated.
+func (t *twitter) Replies(statusID string) ([]reply, error) {
+	id, err := strconv.ParseInt(statusID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	tweet, err := t.api.GetTweet(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	posted, err := tweet.CreatedAtTime()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := searchReplies(id, func(v url.Values) (anaconda.SearchResponse, error) {
+		return t.api.GetSearch("to:"+tweet.User.ScreenName, v)
+	})
+	if err == nil && time.Since(posted) > twitterSearchWindow {
+		err = errorRepliesTruncated
+	}
+	return replies, err
+}
+
+// searchReplies pages through search from the newest tweet back to the one with ID id, and
+// returns the replies to it. Past twitterSearchPages pages it returns what it found with
+// errorRepliesTruncated.
+func searchReplies(id int64, search func(url.Values) (anaconda.SearchResponse, error)) ([]reply, error) {
+	statusID := strconv.FormatInt(id, 10)
+	v := url.Values{"since_id": {statusID}, "count": {"100"}}
+
+	var replies []reply
+	for page := 0; page < twitterSearchPages; page++ {
+		r, err := search(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Statuses) == 0 {
+			return replies, nil
+		}
+
+		oldest := r.Statuses[0].Id
+		for _, s := range r.Statuses {
+			if s.Id < oldest {
+				oldest = s.Id
+			}
+			if s.InReplyToStatusIdStr != statusID {
+				continue
+			}
+			posted, err := s.CreatedAtTime()
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, reply{user{s.User.IdStr, s.User.ScreenName}, posted})
+		}
+		if oldest-1 <= id {
+			return replies, nil
+		}
+		v.Set("max_id", strconv.FormatInt(oldest-1, 10))
+	}
+	return replies, errorRepliesTruncated
+}